@@ -0,0 +1,177 @@
+package main
+
+// ATA IDENTIFY DEVICE decoding. The 512 byte response is read as 256 little
+// endian words; ATA strings store two characters per word, high byte first.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	ataCmdIdentify = 0xec
+)
+
+var ataFormFactorText = map[uint16]string{
+	0: "Not reported",
+	1: "5.25 inch",
+	2: "3.5 inch",
+	3: "2.5 inch",
+	4: "1.8 inch",
+	5: "Less than 1.8 inch",
+	6: "mSATA",
+	7: "M.2",
+	8: "MicroSSD",
+	9: "CFast",
+}
+
+type ataFeature struct {
+	Supported bool `json:"supported"`
+	Enabled   bool `json:"enabled"`
+}
+
+type ataSecurity struct {
+	Supported bool `json:"supported"`
+	Enabled   bool `json:"enabled"`
+	Locked    bool `json:"locked"`
+	Frozen    bool `json:"frozen"`
+}
+
+type ataTrim struct {
+	Supported     bool `json:"supported"`
+	Deterministic bool `json:"deterministic"`
+	ZeroAfterTrim bool `json:"zero_after_trim"`
+}
+
+type ataIdentity struct {
+	Model          string      `json:"model"`
+	Serial         string      `json:"serial"`
+	Firmware       string      `json:"firmware"`
+	WWN            string      `json:"wwn,omitempty"`
+	LogicalSector  uint32      `json:"logical_sector_size"`
+	PhysicalSector uint32      `json:"physical_sector_size"`
+	FormFactor     string      `json:"form_factor"`
+	RotationRate   string      `json:"rotation_rate"`
+	Trim           ataTrim     `json:"trim"`
+	NCQ            ataFeature  `json:"ncq"`
+	NCQDepth       uint16      `json:"ncq_depth,omitempty"`
+	Smart          ataFeature  `json:"smart"`
+	Security       ataSecurity `json:"security"`
+	WriteCache     ataFeature  `json:"write_cache"`
+	ReadLookAhead  ataFeature  `json:"read_look_ahead"`
+}
+
+// ataString : decode a byte swapped ATA string spanning words [first, last]
+func ataString(words []uint16, first int, last int) string {
+	var b strings.Builder
+	for _, w := range words[first : last+1] {
+		b.WriteByte(byte(w >> 8))
+		b.WriteByte(byte(w))
+	}
+	return strings.TrimSpace(strings.TrimRight(b.String(), "\x00"))
+}
+
+// ataWordsValid : words 83 and 87 (and 106) flag their contents valid with bit 14 set and bit 15 clear
+func ataWordsValid(w uint16) bool {
+	return w&0xc000 == 0x4000
+}
+
+// decodeAtaIdentify : decode an IDENTIFY DEVICE sector
+func decodeAtaIdentify(buf []byte, ncqEnabled bool) *ataIdentity {
+	words := make([]uint16, ataSectorSize/2)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint16(buf[i*2:])
+	}
+
+	id := &ataIdentity{
+		Model:          ataString(words, 27, 46),
+		Serial:         ataString(words, 10, 19),
+		Firmware:       ataString(words, 23, 26),
+		LogicalSector:  ataSectorSize,
+		PhysicalSector: ataSectorSize,
+		FormFactor:     ataFormFactorText[words[168]&0x0f],
+	}
+	if id.FormFactor == "" {
+		id.FormFactor = "Reserved"
+	}
+
+	// words 82-84 are only meaningful when word 83 says so, likewise 85-87 with word 87
+	supportValid := ataWordsValid(words[83])
+	enabledValid := ataWordsValid(words[87])
+
+	if supportValid && words[84]&0x0100 != 0 {
+		id.WWN = fmt.Sprintf("%04x%04x%04x%04x", words[108], words[109], words[110], words[111])
+	}
+
+	if ataWordsValid(words[106]) {
+		if words[106]&0x1000 != 0 {
+			id.LogicalSector = 2 * (uint32(words[118])<<16 | uint32(words[117]))
+		}
+		id.PhysicalSector = id.LogicalSector
+		if words[106]&0x2000 != 0 {
+			id.PhysicalSector = id.LogicalSector << (words[106] & 0x0f)
+		}
+	}
+
+	switch rate := words[217]; {
+	case rate == 0:
+		id.RotationRate = "Not reported"
+	case rate == 1:
+		id.RotationRate = "Non-rotating medium"
+	case rate >= 0x0401 && rate < 0xffff:
+		id.RotationRate = strconv.Itoa(int(rate)) + " rpm"
+	default:
+		id.RotationRate = "Reserved"
+	}
+
+	id.Trim = ataTrim{
+		Supported:     words[169]&0x0001 != 0,
+		Deterministic: words[69]&0x4000 != 0,
+		ZeroAfterTrim: words[69]&0x0020 != 0,
+	}
+
+	// NCQ has no enable bit in IDENTIFY; the kernel uses it whenever the queue depth is above one
+	id.NCQ.Supported = words[76] != 0xffff && words[76]&0x0100 != 0
+	if id.NCQ.Supported {
+		id.NCQDepth = words[75]&0x1f + 1
+		id.NCQ.Enabled = ncqEnabled
+	}
+
+	if supportValid {
+		id.Smart.Supported = words[82]&0x0001 != 0
+		id.WriteCache.Supported = words[82]&0x0020 != 0
+		id.ReadLookAhead.Supported = words[82]&0x0040 != 0
+		id.Security.Supported = words[82]&0x0002 != 0
+	}
+	if enabledValid {
+		id.Smart.Enabled = words[85]&0x0001 != 0
+		id.WriteCache.Enabled = words[85]&0x0020 != 0
+		id.ReadLookAhead.Enabled = words[85]&0x0040 != 0
+	}
+	if id.Security.Supported {
+		id.Security.Enabled = words[128]&0x0002 != 0
+		id.Security.Locked = words[128]&0x0004 != 0
+		id.Security.Frozen = words[128]&0x0008 != 0
+	}
+	return id
+}
+
+// ataIdentifyGet : issue IDENTIFY DEVICE to an ATA disk and decode the response
+func ataIdentifyGet(devPath string) (*ataIdentity, error) {
+	f, err := openPassThrough(devPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, ataSectorSize)
+	if err = ataReadPIO(f, ataCmdIdentify, 0, 0, 0, buf); err != nil {
+		return nil, err
+	}
+
+	queueDepth, _ := getDeviceAttr(devPath, "queue_depth")
+	depth, _ := strconv.Atoi(queueDepth)
+	return decodeAtaIdentify(buf, depth > 1), nil
+}
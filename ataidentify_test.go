@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// identifySector : an IDENTIFY DEVICE sector with the given strings starting at their words and the given words set
+func identifySector(strs map[int]string, words map[int]uint16) []byte {
+	buf := make([]byte, ataSectorSize)
+	for word, s := range strs {
+		for i := 0; i < len(s); i += 2 {
+			w := uint16(s[i]) << 8
+			if i+1 < len(s) {
+				w |= uint16(s[i+1])
+			}
+			binary.LittleEndian.PutUint16(buf[(word+i/2)*2:], w)
+		}
+	}
+	for word, value := range words {
+		binary.LittleEndian.PutUint16(buf[word*2:], value)
+	}
+	return buf
+}
+
+var ataIdentifyTests = []struct {
+	name string
+	buf  []byte
+	ncq  bool
+	want ataIdentity
+}{
+	{
+		name: "512e hard disk",
+		buf: identifySector(
+			map[int]string{10: "            ZC1234AB", 23: "SN03    ", 27: "ST4000NM0035-1V4107                     "},
+			map[int]uint16{
+				75: 31, 76: 0x0100,
+				82: 0x0063, 83: 0x4000, 84: 0x0100, 85: 0x0021, 87: 0x4000,
+				106: 0x6003, 108: 0x5000, 109: 0xc500, 110: 0xa1b2, 111: 0xc3d4,
+				128: 0x0009, 168: 2, 217: 7200,
+			}),
+		ncq: true,
+		want: ataIdentity{Model: "ST4000NM0035-1V4107", Serial: "ZC1234AB", Firmware: "SN03", WWN: "5000c500a1b2c3d4",
+			LogicalSector: 512, PhysicalSector: 4096, FormFactor: "3.5 inch", RotationRate: "7200 rpm",
+			NCQ: ataFeature{Supported: true, Enabled: true}, NCQDepth: 32,
+			Smart: ataFeature{true, true}, Security: ataSecurity{Supported: true, Frozen: true},
+			WriteCache: ataFeature{true, true}, ReadLookAhead: ataFeature{true, false}},
+	},
+	{
+		name: "4Kn solid state disk, command set words not valid",
+		buf: identifySector(
+			map[int]string{10: "S4EWNX0R123456", 23: "EDA7\x00\x00\x00\x00", 27: "Samsung SSD 860 PRO 1TB"},
+			map[int]uint16{
+				69: 0x4020, 76: 0xffff,
+				82: 0x0063, 83: 0x0000, 84: 0x0100, 85: 0x0061, 87: 0xc000,
+				106: 0x5000, 117: 0x0800, 168: 7, 169: 0x0001, 217: 1,
+			}),
+		want: ataIdentity{Model: "Samsung SSD 860 PRO 1TB", Serial: "S4EWNX0R123456", Firmware: "EDA7",
+			LogicalSector: 4096, PhysicalSector: 4096, FormFactor: "M.2", RotationRate: "Non-rotating medium",
+			Trim: ataTrim{Supported: true, Deterministic: true, ZeroAfterTrim: true}},
+	},
+	{
+		name: "reserved values",
+		buf:  identifySector(nil, map[int]uint16{76: 0x0100, 168: 0x0f, 217: 0x0200}),
+		want: ataIdentity{LogicalSector: 512, PhysicalSector: 512, FormFactor: "Reserved", RotationRate: "Reserved",
+			NCQ: ataFeature{Supported: true}, NCQDepth: 1},
+	},
+}
+
+func TestDecodeAtaIdentify(t *testing.T) {
+	for _, test := range ataIdentifyTests {
+		if got := decodeAtaIdentify(test.buf, test.ncq); !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("%s: decodeAtaIdentify =\n%+v\nwant\n%+v", test.name, *got, test.want)
+		}
+	}
+}
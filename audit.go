@@ -0,0 +1,251 @@
+package main
+
+// Audit log. Every change localdisk makes to a disk on the live host (LED
+// switches, including those of led locate, restore and led-agent, and IBPI
+// patterns) is appended as one JSON line to -audit-log: who ran it, on which
+// host, against which disk by serial number and WWID, what was asked for and
+// what came of it. `localdisk history` reads it back.
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var auditLogPath = "/var/log/localdisk/audit.jsonl"
+
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Uid       int       `json:"uid"`
+	SudoUser  string    `json:"sudo_user,omitempty"`
+	Host      string    `json:"host"`
+	Pid       int       `json:"pid"`
+	Command   string    `json:"command"`
+	Operation string    `json:"operation"`
+	Device    string    `json:"device"`
+	Serial    string    `json:"serial,omitempty"`
+	Wwid      string    `json:"wwid,omitempty"`
+	Requested string    `json:"requested"`
+	Before    string    `json:"before"`
+	Result    string    `json:"result"`
+	Mechanism string    `json:"mechanism,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// addAuditLogFlag : register the -audit-log flag on a flag set
+func addAuditLogFlag(fs *flag.FlagSet) {
+	fs.StringVar(&auditLogPath, "audit-log", "/var/log/localdisk/audit.jsonl", "file every change to a disk is appended to")
+}
+
+// appendAudit : append an entry to the audit log
+func appendAudit(entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(auditLogPath), 0750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(auditLogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	// a single write per entry, so concurrent runs never interleave within a line
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// auditChange : record a LED change in the audit log, warning when it couldn't be recorded
+func auditChange(change ledChange) ledChange {
+	if !liveHost() {
+		// nothing on this host was touched
+		return change
+	}
+	entry := auditEntry{
+		Time:      time.Now(),
+		Uid:       os.Getuid(),
+		SudoUser:  os.Getenv("SUDO_USER"),
+		Pid:       os.Getpid(),
+		Command:   strings.Join(os.Args[1:], " "),
+		Operation: change.Led + " " + strings.ToLower(change.Requested),
+		Device:    change.Device,
+		Requested: change.Requested,
+		Before:    change.Before,
+		Result:    change.After,
+		Mechanism: change.Mechanism,
+		Error:     change.Error,
+	}
+	entry.User = strconv.Itoa(entry.Uid)
+	if u, err := user.LookupId(entry.User); err == nil {
+		entry.User = u.Username
+	}
+	entry.Host, _ = os.Hostname()
+	entry.Wwid, entry.Serial = diskIdentity(change.Device)
+
+	if err := appendAudit(entry); err != nil {
+		// the disk was changed all the same, so the result stands
+		change.Warning = "the change wasn't written to the audit log: " + err.Error()
+	}
+	return change
+}
+
+// readAudit : the entries of the audit log, oldest first, and the number of lines that couldn't be read
+func readAudit() ([]auditEntry, int, error) {
+	f, err := os.Open(auditLogPath)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	bad := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// a line cut short by a full disk or a crash
+			bad++
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, 0, errors.New("Unable to read " + auditLogPath + ": " + err.Error())
+	}
+	return entries, bad, nil
+}
+
+// historyIdentity : the WWID and serial number of the disk a history selector names on the live host,
+// empty when it can't be resolved, e.g. because the disk has been pulled
+func historyIdentity(selector string) (string, string) {
+	if !liveHost() || backend == nil {
+		return "", ""
+	}
+	devPath, err := resolveDisk(selector)
+	if err != nil {
+		return "", ""
+	}
+	return diskIdentity(devPath)
+}
+
+// auditMatchesDisk : true when an entry is about the disk with the given identity, or when that isn't
+// known, about the device, device name, serial number or WWID the selector names
+func auditMatchesDisk(entry auditEntry, selector string, wwid string, serial string) bool {
+	sameWwid := func(a string, b string) bool {
+		return a != "" && strings.EqualFold(strings.TrimPrefix(a, "naa."), strings.TrimPrefix(b, "naa."))
+	}
+	if wwid != "" || serial != "" {
+		return sameWwid(wwid, entry.Wwid) || (serial != "" && serial == entry.Serial)
+	}
+	devName, _ := extractDev(entry.Device)
+	return selector == entry.Device || selector == devName ||
+		(entry.Serial != "" && selector == entry.Serial) || sameWwid(entry.Wwid, selector)
+}
+
+// auditText : one line of history
+func auditText(entry auditEntry) string {
+	who := entry.User
+	if entry.SudoUser != "" {
+		who = entry.SudoUser + " as " + entry.User
+	}
+	identity := entry.Serial
+	if identity == "" {
+		identity = entry.Wwid
+	}
+	if identity == "" {
+		identity = "unidentified"
+	}
+	result := entry.Before + " -> " + entry.Result
+	if entry.Error != "" {
+		result = "failed: " + entry.Error
+	}
+	return fmt.Sprintf("%s %s@%s %s (%s) %s: %s [localdisk %s, pid %d]",
+		entry.Time.Local().Format(time.RFC3339), who, entry.Host, entry.Device, identity,
+		entry.Operation, result, entry.Command, entry.Pid)
+}
+
+// historyCommand : the history subcommand, returning the exit code
+func historyCommand(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	fs.StringVar(&outputFormat, "output", "text", "output format (text or json)")
+	diskSelector := fs.String("disk", "", "only changes to this device, device name, serial number or WWID")
+	since := fs.Duration("since", 0, "only changes made in this long, e.g. 24h")
+	operation := fs.String("led", "", "only changes to this LED (ident, fault or pattern)")
+	last := fs.Int("last", 0, "only the most recent changes (0 shows all)")
+	addAuditLogFlag(fs)
+	addBackendFlag(fs)
+	fs.Parse(args)
+
+	if outputFormat != "text" && outputFormat != "json" {
+		fmt.Println("Unsupported output format " + outputFormat)
+		return 1
+	}
+	if fs.NArg() != 0 {
+		fmt.Println("Usage: localdisk history [-disk <disk>] [-since <duration>] [-led <led>] [-last <n>]")
+		fs.PrintDefaults()
+		return 1
+	}
+
+	entries, bad, err := readAudit()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	var wwid, serial string
+	if *diskSelector != "" {
+		// without a backend the selector is only compared with what was recorded
+		if initBackend() == nil {
+			wwid, serial = historyIdentity(*diskSelector)
+		}
+	}
+	found := []auditEntry{}
+	for _, entry := range entries {
+		if *diskSelector != "" && !auditMatchesDisk(entry, *diskSelector, wwid, serial) {
+			continue
+		}
+		if *since > 0 && time.Since(entry.Time) > *since {
+			continue
+		}
+		if *operation != "" && !strings.HasPrefix(entry.Operation, *operation+" ") {
+			continue
+		}
+		found = append(found, entry)
+	}
+	if *last > 0 && len(found) > *last {
+		found = found[len(found)-*last:]
+	}
+
+	if outputFormat == "json" {
+		printJSON(found)
+		return 0
+	}
+	if len(found) == 0 {
+		fmt.Println("No recorded changes match")
+	}
+	for _, entry := range found {
+		fmt.Println(auditText(entry))
+	}
+	if bad > 0 {
+		fmt.Printf("%d unreadable lines in %s were skipped\n", bad, auditLogPath)
+	}
+	return 0
+}
@@ -0,0 +1,96 @@
+package main
+
+// Disk inventory backends. The lsm backend loads the libstoragemgmt localdisk
+// API at runtime and is only compiled in with cgo; the sysfs backend is pure
+// Go and builds with CGO_ENABLED=0. Backends register themselves from init().
+
+import (
+	"errors"
+	"flag"
+	"sort"
+	"strings"
+
+	lsm "github.com/libstorage/libstoragemgmt-golang"
+)
+
+// rpm values used by libstoragemgmt (lsm_disk_rpm in libstoragemgmt_types.h)
+const (
+	diskRpmNoSupport            = -2
+	diskRpmUnknown              = -1
+	diskRpmNonRotatingMedium    = 0
+	diskRpmRotatingUnknownSpeed = 1
+)
+
+// reported in place of the fields a backend fills when its library can't be loaded
+const lsmUnavailableText = "lsm unavailable"
+
+type diskBackend interface {
+	name() string
+	available() error
+	list() ([]string, error)
+	serialNum(devPath string) (string, error)
+	vpd83(devPath string) (string, error)
+	healthStatus(devPath string) (lsm.DiskHealthStatus, error)
+	rpm(devPath string) (int32, error)
+	linkType(devPath string) (lsm.DiskLinkType, error)
+	linkSpeed(devPath string) (uint32, error)
+	ledStatus(devPath string) (lsm.DiskLedStatusBitField, error)
+	faultLedOn(devPath string) error
+	faultLedOff(devPath string) error
+	identLedOn(devPath string) error
+	identLedOff(devPath string) error
+}
+
+var backendFactories = map[string]func() diskBackend{}
+
+var backendName = "auto"
+var backend diskBackend
+
+// addBackendFlag : register the -backend flag on a flag set
+func addBackendFlag(fs *flag.FlagSet) {
+	fs.StringVar(&backendName, "backend", "auto", "disk backend: "+strings.Join(backendNames(), ", ")+" or auto")
+}
+
+// backendNames : the backends compiled into this binary
+func backendNames() []string {
+	var names []string
+	for name := range backendFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectBackend : pick the named backend, or for auto the lsm backend when it loads and works and sysfs otherwise
+func selectBackend(name string) (diskBackend, error) {
+	if name == "auto" {
+		name = "sysfs"
+		if replayInventory != nil {
+			name = "replay"
+		}
+		// libstoragemgmt can only look at the running system
+		if factory, ok := backendFactories["lsm"]; ok && liveHost() && replayInventory == nil {
+			b := factory()
+			if b.available() == nil {
+				if _, err := b.list(); err == nil {
+					return b, nil
+				}
+			}
+		}
+	}
+	if name == "lsm" && !liveHost() {
+		return nil, errors.New("The lsm backend can't read a captured tree, use -backend sysfs")
+	}
+	factory, ok := backendFactories[name]
+	if !ok {
+		return nil, errors.New("Backend " + name + " is not available in this build (have " + strings.Join(backendNames(), ", ") + ")")
+	}
+	return factory(), nil
+}
+
+// initBackend : select the backend requested on the command line
+func initBackend() error {
+	var err error
+	backend, err = selectBackend(backendName)
+	return err
+}
@@ -0,0 +1,293 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+// The lsm backend binds the libstoragemgmt localdisk API at runtime with
+// dlopen/dlsym instead of linking against it, so the same binary starts on
+// hosts without libstoragemgmt installed. Only libdl is needed at build time;
+// the libstoragemgmt types are treated as opaque pointers.
+
+/*
+#cgo LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+static void *lsm_handle;
+
+static const char *lsm_open(void) {
+	const char *names[] = {"libstoragemgmt.so.1", "libstoragemgmt.so", NULL};
+	int i;
+
+	for (i = 0; names[i] != NULL && lsm_handle == NULL; i++) {
+		lsm_handle = dlopen(names[i], RTLD_NOW | RTLD_GLOBAL);
+	}
+	return lsm_handle == NULL ? dlerror() : NULL;
+}
+
+static void *lsm_sym(const char *name) {
+	return dlsym(lsm_handle, name);
+}
+
+static int lsm_call_list(void *fn, void **list, void **err) {
+	return ((int (*)(void **, void **))fn)(list, err);
+}
+
+static int lsm_call_str_get(void *fn, const char *path, char **out, void **err) {
+	return ((int (*)(const char *, char **, void **))fn)(path, out, err);
+}
+
+static int lsm_call_i32_get(void *fn, const char *path, int32_t *out, void **err) {
+	return ((int (*)(const char *, int32_t *, void **))fn)(path, out, err);
+}
+
+static int lsm_call_u32_get(void *fn, const char *path, uint32_t *out, void **err) {
+	return ((int (*)(const char *, uint32_t *, void **))fn)(path, out, err);
+}
+
+static int lsm_call_set(void *fn, const char *path, void **err) {
+	return ((int (*)(const char *, void **))fn)(path, err);
+}
+
+static uint32_t lsm_call_list_size(void *fn, void *list) {
+	return ((uint32_t (*)(void *))fn)(list);
+}
+
+static const char *lsm_call_list_elem(void *fn, void *list, uint32_t i) {
+	return ((const char *(*)(void *, uint32_t))fn)(list, i);
+}
+
+static int lsm_call_free(void *fn, void *p) {
+	return ((int (*)(void *))fn)(p);
+}
+
+static int lsm_call_error_number(void *fn, void *err) {
+	return ((int (*)(void *))fn)(err);
+}
+
+static const char *lsm_call_error_message(void *fn, void *err) {
+	return ((const char *(*)(void *))fn)(err);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"sync"
+	"unsafe"
+
+	lsm "github.com/libstorage/libstoragemgmt-golang"
+	lsmerrors "github.com/libstorage/libstoragemgmt-golang/errors"
+)
+
+var lsmSymbolNames = []string{
+	"lsm_error_free",
+	"lsm_error_message_get",
+	"lsm_error_number_get",
+	"lsm_string_list_size",
+	"lsm_string_list_elem_get",
+	"lsm_string_list_free",
+	"lsm_local_disk_list",
+	"lsm_local_disk_serial_num_get",
+	"lsm_local_disk_vpd83_get",
+	"lsm_local_disk_health_status_get",
+	"lsm_local_disk_rpm_get",
+	"lsm_local_disk_link_type_get",
+	"lsm_local_disk_link_speed_get",
+	"lsm_local_disk_led_status_get",
+	"lsm_local_disk_fault_led_on",
+	"lsm_local_disk_fault_led_off",
+	"lsm_local_disk_ident_led_on",
+	"lsm_local_disk_ident_led_off",
+}
+
+var lsmLoad sync.Once
+var lsmLoadErr error
+var lsmSymbols = map[string]unsafe.Pointer{}
+
+// lsmOpen : load libstoragemgmt and resolve the localdisk symbols, once
+func lsmOpen() error {
+	lsmLoad.Do(func() {
+		if msg := C.lsm_open(); msg != nil {
+			lsmLoadErr = errors.New("lsm unavailable: " + C.GoString(msg))
+			return
+		}
+		for _, name := range lsmSymbolNames {
+			cname := C.CString(name)
+			sym := C.lsm_sym(cname)
+			C.free(unsafe.Pointer(cname))
+			if sym == nil {
+				lsmLoadErr = errors.New("lsm unavailable: libstoragemgmt has no " + name)
+				return
+			}
+			lsmSymbols[name] = sym
+		}
+	})
+	return lsmLoadErr
+}
+
+// lsmError : convert a libstoragemgmt return code and error object to a Go error
+func lsmError(rc C.int, e unsafe.Pointer) error {
+	if e != nil {
+		defer C.lsm_call_free(lsmSymbols["lsm_error_free"], e)
+		return &lsmerrors.LsmError{
+			Code:    int32(C.lsm_call_error_number(lsmSymbols["lsm_error_number_get"], e)),
+			Message: C.GoString(C.lsm_call_error_message(lsmSymbols["lsm_error_message_get"], e))}
+	}
+	if rc != 0 {
+		return &lsmerrors.LsmError{Code: int32(rc)}
+	}
+	return nil
+}
+
+// lsmStringGet : call a localdisk getter returning an allocated string
+func lsmStringGet(fn string, devPath string) (string, error) {
+	if err := lsmOpen(); err != nil {
+		return "", err
+	}
+	dp := C.CString(devPath)
+	defer C.free(unsafe.Pointer(dp))
+
+	var out *C.char
+	var e unsafe.Pointer
+	rc := C.lsm_call_str_get(lsmSymbols[fn], dp, &out, &e)
+	if rc != 0 {
+		return "", lsmError(rc, e)
+	}
+	value := C.GoString(out)
+	C.free(unsafe.Pointer(out))
+	return value, nil
+}
+
+// lsmInt32Get : call a localdisk getter returning an int32_t
+func lsmInt32Get(fn string, devPath string) (int32, error) {
+	if err := lsmOpen(); err != nil {
+		return -1, err
+	}
+	dp := C.CString(devPath)
+	defer C.free(unsafe.Pointer(dp))
+
+	var out C.int32_t
+	var e unsafe.Pointer
+	if rc := C.lsm_call_i32_get(lsmSymbols[fn], dp, &out, &e); rc != 0 {
+		return -1, lsmError(rc, e)
+	}
+	return int32(out), nil
+}
+
+// lsmUint32Get : call a localdisk getter returning a uint32_t
+func lsmUint32Get(fn string, devPath string) (uint32, error) {
+	if err := lsmOpen(); err != nil {
+		return 0, err
+	}
+	dp := C.CString(devPath)
+	defer C.free(unsafe.Pointer(dp))
+
+	var out C.uint32_t
+	var e unsafe.Pointer
+	if rc := C.lsm_call_u32_get(lsmSymbols[fn], dp, &out, &e); rc != 0 {
+		return 0, lsmError(rc, e)
+	}
+	return uint32(out), nil
+}
+
+// lsmSet : call a localdisk LED setter
+func lsmSet(fn string, devPath string) error {
+	if err := lsmOpen(); err != nil {
+		return err
+	}
+	dp := C.CString(devPath)
+	defer C.free(unsafe.Pointer(dp))
+
+	var e unsafe.Pointer
+	return lsmError(C.lsm_call_set(lsmSymbols[fn], dp, &e), e)
+}
+
+type lsmBackend struct{}
+
+func init() {
+	backendFactories["lsm"] = func() diskBackend { return lsmBackend{} }
+}
+
+func (lsmBackend) name() string {
+	return "lsm"
+}
+
+func (lsmBackend) available() error {
+	return lsmOpen()
+}
+
+func (lsmBackend) list() ([]string, error) {
+	if err := lsmOpen(); err != nil {
+		// without the library the disks are still enumerated from sysfs
+		return sysfsBackend{}.list()
+	}
+
+	var list unsafe.Pointer
+	var e unsafe.Pointer
+	if rc := C.lsm_call_list(lsmSymbols["lsm_local_disk_list"], &list, &e); rc != 0 {
+		return nil, lsmError(rc, e)
+	}
+	var disks []string
+	num := C.lsm_call_list_size(lsmSymbols["lsm_string_list_size"], list)
+	for i := C.uint32_t(0); i < num; i++ {
+		disks = append(disks, C.GoString(C.lsm_call_list_elem(lsmSymbols["lsm_string_list_elem_get"], list, i)))
+	}
+	C.lsm_call_free(lsmSymbols["lsm_string_list_free"], list)
+	return disks, nil
+}
+
+func (lsmBackend) serialNum(devPath string) (string, error) {
+	return lsmStringGet("lsm_local_disk_serial_num_get", devPath)
+}
+
+func (lsmBackend) vpd83(devPath string) (string, error) {
+	return lsmStringGet("lsm_local_disk_vpd83_get", devPath)
+}
+
+func (lsmBackend) healthStatus(devPath string) (lsm.DiskHealthStatus, error) {
+	health, err := lsmInt32Get("lsm_local_disk_health_status_get", devPath)
+	return lsm.DiskHealthStatus(health), err
+}
+
+func (lsmBackend) rpm(devPath string) (int32, error) {
+	return lsmInt32Get("lsm_local_disk_rpm_get", devPath)
+}
+
+func (lsmBackend) linkType(devPath string) (lsm.DiskLinkType, error) {
+	// lsm_disk_link_type is a C enum, which is int sized
+	linkType, err := lsmInt32Get("lsm_local_disk_link_type_get", devPath)
+	if err != nil {
+		return lsm.DiskLinkTypeUnknown, err
+	}
+	return lsm.DiskLinkType(linkType), nil
+}
+
+func (lsmBackend) linkSpeed(devPath string) (uint32, error) {
+	return lsmUint32Get("lsm_local_disk_link_speed_get", devPath)
+}
+
+func (lsmBackend) ledStatus(devPath string) (lsm.DiskLedStatusBitField, error) {
+	status, err := lsmUint32Get("lsm_local_disk_led_status_get", devPath)
+	if err != nil {
+		return lsm.DiskLedStatusUnknown, err
+	}
+	return lsm.DiskLedStatusBitField(status), nil
+}
+
+func (lsmBackend) faultLedOn(devPath string) error {
+	return lsmSet("lsm_local_disk_fault_led_on", devPath)
+}
+
+func (lsmBackend) faultLedOff(devPath string) error {
+	return lsmSet("lsm_local_disk_fault_led_off", devPath)
+}
+
+func (lsmBackend) identLedOn(devPath string) error {
+	return lsmSet("lsm_local_disk_ident_led_on", devPath)
+}
+
+func (lsmBackend) identLedOff(devPath string) error {
+	return lsmSet("lsm_local_disk_ident_led_off", devPath)
+}
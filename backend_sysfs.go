@@ -0,0 +1,283 @@
+package main
+
+// Pure Go backend built from sysfs attributes and the VPD pages the kernel
+// caches for SCSI devices. It needs neither cgo nor libstoragemgmt, so a
+// CGO_ENABLED=0 build still produces a working inventory. Disk health is
+// left to the pass-through collectors (SMART, SCSI log pages, NVMe health).
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	lsm "github.com/libstorage/libstoragemgmt-golang"
+)
+
+// block devices that are never local disks
+var virtualBlockPrefixes = []string{"loop", "ram", "zram", "dm-", "md", "sr", "nbd", "rbd"}
+
+type sysfsBackend struct{}
+
+func init() {
+	backendFactories["sysfs"] = func() diskBackend { return sysfsBackend{} }
+}
+
+func (sysfsBackend) name() string {
+	return "sysfs"
+}
+
+// blockSysfsPath : the sysfs directory of a block device
+func blockSysfsPath(devPath string) string {
+	devName, _ := extractDev(devPath)
+	return "/sys/class/block/" + devName
+}
+
+func (sysfsBackend) available() error {
+	return nil
+}
+
+func (sysfsBackend) list() ([]string, error) {
+	entries, err := ioutil.ReadDir(hostPath("/sys/class/block"))
+	if err != nil {
+		return nil, err
+	}
+
+	var disks []string
+	for _, entry := range entries {
+		devName := entry.Name()
+		virtual := false
+		for _, prefix := range virtualBlockPrefixes {
+			if strings.HasPrefix(devName, prefix) {
+				virtual = true
+			}
+		}
+		if virtual {
+			continue
+		}
+		sysPath := "/sys/class/block/" + devName
+		if _, err := os.Stat(hostPath(sysPath + "/partition")); err == nil {
+			continue
+		}
+		if _, err := os.Stat(hostPath(sysPath + "/device")); err != nil {
+			continue
+		}
+		// multipath NVMe exposes per-path namespaces as hidden block devices
+		if hidden, _ := readFile(sysPath + "/hidden"); hidden == "1" {
+			continue
+		}
+		disks = append(disks, "/dev/"+devName)
+	}
+	sort.Strings(disks)
+	return disks, nil
+}
+
+func (sysfsBackend) serialNum(devPath string) (string, error) {
+	sysPath := blockSysfsPath(devPath)
+	if page, err := ioutil.ReadFile(hostPath(sysPath + "/device/vpd_pg80")); err == nil && len(page) > 4 {
+		length := int(binary.BigEndian.Uint16(page[2:4]))
+		if 4+length <= len(page) {
+			return strings.TrimSpace(string(page[4 : 4+length])), nil
+		}
+	}
+	// NVMe keeps the serial on the controller, virtio-blk on the block device
+	for _, attr := range []string{"/device/serial", "/serial"} {
+		if serial, err := readFile(sysPath + attr); err == nil && serial != "" {
+			return serial, nil
+		}
+	}
+	if serial := udevProperties(devPath)["ID_SERIAL_SHORT"]; serial != "" {
+		return serial, nil
+	}
+	return "", errors.New("no serial number found for " + devPath)
+}
+
+func (sysfsBackend) vpd83(devPath string) (string, error) {
+	sysPath := blockSysfsPath(devPath)
+	if page, err := ioutil.ReadFile(hostPath(sysPath + "/device/vpd_pg83")); err == nil {
+		for _, d := range decodeDeviceID(page) {
+			if d.Association == associationText[0] && d.Type == designatorTypeText[designatorTypeNAA] {
+				return strings.TrimPrefix(d.Value, "naa."), nil
+			}
+		}
+	}
+	for _, attr := range []string{"/nguid", "/eui"} {
+		if id, err := readFile(sysPath + attr); err == nil && strings.Trim(id, "0- ") != "" {
+			return strings.Replace(id, "-", "", -1), nil
+		}
+	}
+	if wwn := udevProperties(devPath)["ID_WWN"]; wwn != "" {
+		return strings.TrimPrefix(wwn, "0x"), nil
+	}
+	return "", errors.New("no VPD 0x83 NAA identifier found for " + devPath)
+}
+
+func (sysfsBackend) healthStatus(devPath string) (lsm.DiskHealthStatus, error) {
+	return lsm.DiskHealthStatusUnknown, nil
+}
+
+func (sysfsBackend) rpm(devPath string) (int32, error) {
+	sysPath := blockSysfsPath(devPath)
+	rotational, err := readFile(sysPath + "/queue/rotational")
+	if err != nil {
+		return diskRpmUnknown, err
+	}
+	if rotational == "0" {
+		return diskRpmNonRotatingMedium, nil
+	}
+	if page, err := ioutil.ReadFile(hostPath(sysPath + "/device/vpd_pgb1")); err == nil && len(page) >= 6 {
+		rate := binary.BigEndian.Uint16(page[4:6])
+		if rate > rotationNonRotating && rate < 0xffff {
+			return int32(rate), nil
+		}
+	}
+	return diskRpmRotatingUnknownSpeed, nil
+}
+
+// deviceRealPath : the resolved sysfs path of the device behind a block device
+func deviceRealPath(devPath string) (string, error) {
+	return filepath.EvalSymlinks(hostPath(blockSysfsPath(devPath) + "/device"))
+}
+
+func (sysfsBackend) linkType(devPath string) (lsm.DiskLinkType, error) {
+	realPath, err := deviceRealPath(devPath)
+	if err != nil {
+		return lsm.DiskLinkTypeUnknown, err
+	}
+	for _, component := range strings.Split(realPath, "/") {
+		switch {
+		case strings.HasPrefix(component, "nvme"):
+			return lsm.DiskLinkTypePciE, nil
+		case strings.HasPrefix(component, "usb"):
+			return lsm.DiskLinkTypeUsb, nil
+		case strings.HasPrefix(component, "ata"):
+			return lsm.DiskLinkTypeAta, nil
+		case strings.HasPrefix(component, "end_device-"):
+			return lsm.DiskLinkTypeSas, nil
+		case strings.HasPrefix(component, "rport-"):
+			return lsm.DiskLinkTypeFc, nil
+		case strings.HasPrefix(component, "session"):
+			return lsm.DiskLinkTypeIscsi, nil
+		}
+	}
+	return lsm.DiskLinkTypeUnknown, nil
+}
+
+// parseLinkRate : convert a sysfs link rate such as "6.0 Gbps" or "8.0 GT/s PCIe" to Mbps
+func parseLinkRate(rate string) (uint32, error) {
+	fields := strings.Fields(rate)
+	if len(fields) == 0 {
+		return 0, errors.New("empty link rate")
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, errors.New("unrecognised link rate " + rate)
+	}
+	if len(fields) > 1 && strings.HasPrefix(fields[1], "M") {
+		return uint32(value), nil
+	}
+	return uint32(value * 1000), nil
+}
+
+func (sysfsBackend) linkSpeed(devPath string) (uint32, error) {
+	realPath, err := deviceRealPath(devPath)
+	if err != nil {
+		return 0, err
+	}
+
+	dir := realPath
+	for dir != "/" && dir != "." {
+		component := filepath.Base(dir)
+		switch {
+		case strings.HasPrefix(component, "ata") && !strings.Contains(component, "_"):
+			if rate, err := readFile("/sys/class/ata_link/link" + strings.TrimPrefix(component, "ata") + "/sata_spd"); err == nil {
+				return parseLinkRate(rate)
+			}
+		case strings.HasPrefix(component, "port-"):
+			phys, _ := filepath.Glob(dir + "/phy-*")
+			for _, phy := range phys {
+				if rate, err := readFile(phy + "/sas_phy/" + filepath.Base(phy) + "/negotiated_linkrate"); err == nil {
+					return parseLinkRate(rate)
+				}
+			}
+		}
+		if rate, err := readFile(dir + "/current_link_speed"); err == nil {
+			speed, err := parseLinkRate(rate)
+			if err != nil {
+				return 0, err
+			}
+			width, _ := readFile(dir + "/current_link_width")
+			lanes, err := strconv.ParseUint(width, 10, 32)
+			if err != nil || lanes == 0 {
+				lanes = 1
+			}
+			return speed * uint32(lanes), nil
+		}
+		dir = filepath.Dir(dir)
+	}
+	return 0, errors.New("no link speed found for " + devPath)
+}
+
+// enclosureComponent : the SES enclosure slot directory a disk sits in
+func enclosureComponent(devPath string) (string, error) {
+	slots, _ := filepath.Glob(hostPath(blockSysfsPath(devPath) + "/device/enclosure_device:*"))
+	if len(slots) == 0 {
+		return "", errors.New("no enclosure slot found for " + devPath)
+	}
+	return slots[0], nil
+}
+
+func (sysfsBackend) ledStatus(devPath string) (lsm.DiskLedStatusBitField, error) {
+	slot, err := enclosureComponent(devPath)
+	if err != nil {
+		return lsm.DiskLedStatusUnknown, err
+	}
+
+	var status lsm.DiskLedStatusBitField
+	switch locate, _ := readFile(slot + "/locate"); locate {
+	case "0":
+		status |= lsm.DiskLedStatusIdentOff
+	case "1":
+		status |= lsm.DiskLedStatusIdentOn
+	default:
+		status |= lsm.DiskLedStatusIdentUnknown
+	}
+	switch fault, _ := readFile(slot + "/fault"); fault {
+	case "0":
+		status |= lsm.DiskLedStatusFaultOff
+	case "1":
+		status |= lsm.DiskLedStatusFaultOn
+	default:
+		status |= lsm.DiskLedStatusFaultUnknown
+	}
+	return status, nil
+}
+
+// writeEnclosureLed : set an LED attribute of the disk's enclosure slot
+func writeEnclosureLed(devPath string, attr string, value string) error {
+	slot, err := enclosureComponent(devPath)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(slot+"/"+attr, []byte(value), 0644)
+}
+
+func (sysfsBackend) faultLedOn(devPath string) error {
+	return writeEnclosureLed(devPath, "fault", "1")
+}
+
+func (sysfsBackend) faultLedOff(devPath string) error {
+	return writeEnclosureLed(devPath, "fault", "0")
+}
+
+func (sysfsBackend) identLedOn(devPath string) error {
+	return writeEnclosureLed(devPath, "locate", "1")
+}
+
+func (sysfsBackend) identLedOff(devPath string) error {
+	return writeEnclosureLed(devPath, "locate", "0")
+}
@@ -0,0 +1,471 @@
+package main
+
+// The capture subcommand writes everything the collectors read into a
+// tar.gz bundle: the sysfs trees behind the disks, the udev database entries,
+// /proc/mounts and /proc/swaps, plus the recorded backend answers and raw
+// pass-through responses. Extract the bundle and point -root at it to replay
+// the inventory on another machine.
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sysfs directories of symlinks to the devices the collectors look at
+var captureLinkDirs = []string{
+	"/sys/block",
+	"/sys/class/block",
+	"/sys/class/nvme",
+	"/sys/class/nvme-subsystem",
+	"/sys/class/enclosure",
+	"/sys/class/hwmon",
+	"/sys/class/ata_link",
+	"/sys/class/ata_port",
+	"/sys/class/sas_phy",
+	"/sys/class/sas_port",
+	"/sys/class/sas_end_device",
+	"/sys/class/scsi_host",
+	"/sys/class/scsi_device",
+	"/sys/class/scsi_disk",
+	"/sys/class/scsi_generic",
+	"/sys/class/leds",
+	"/sys/bus/pci/slots",
+}
+
+var captureProcFiles = []string{"/proc/mounts", "/proc/swaps"}
+
+// sysfs directories and attributes that are large, binary, slow or have side effects when read;
+// reading a PCI function's vpd can stall for seconds or hang on some devices
+var captureSkipNames = map[string]bool{
+	"power":           true,
+	"rom":             true,
+	"vpd":             true,
+	"eeprom":          true,
+	"config":          true,
+	"resource":        true,
+	"remove":          true,
+	"rescan":          true,
+	"reset":           true,
+	"bind":            true,
+	"unbind":          true,
+	"new_id":          true,
+	"remove_id":       true,
+	"driver_override": true,
+}
+
+const captureMaxFileBytes = 1 << 20
+
+type captureManifest struct {
+	Tool      string    `json:"tool"`
+	Version   string    `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Hostname  string    `json:"hostname"`
+	Kernel    string    `json:"kernel"`
+	Backend   string    `json:"backend"`
+	Disks     []string  `json:"disks"`
+	Redacted  string    `json:"redacted,omitempty"`
+}
+
+type bundleWriter struct {
+	tw      *tar.Writer
+	modTime time.Time
+	written map[string]bool
+	visited map[string]bool
+	redact  *redactor
+}
+
+// bundleName : the archive name of a live path
+func bundleName(path string) string {
+	return strings.TrimPrefix(filepath.Clean(path), "/")
+}
+
+// addDir : add a directory entry and any missing parents
+func (b *bundleWriter) addDir(name string) error {
+	if name == "." || name == "" || b.written[name] {
+		return nil
+	}
+	if err := b.addDir(filepath.Dir(name)); err != nil {
+		return err
+	}
+	b.written[name] = true
+	return b.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0755,
+		ModTime:  b.modTime,
+	})
+}
+
+// addFile : add a regular file
+func (b *bundleWriter) addFile(name string, content []byte, mode int64) error {
+	if b.written[name] {
+		return nil
+	}
+	if err := b.addDir(filepath.Dir(name)); err != nil {
+		return err
+	}
+	b.written[name] = true
+	content = b.redact.Bytes(content)
+	err := b.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     mode,
+		Size:     int64(len(content)),
+		ModTime:  b.modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = b.tw.Write(content)
+	return err
+}
+
+// addSymlink : add a symlink, keeping its (relative) target as is
+func (b *bundleWriter) addSymlink(name string, target string) error {
+	if b.written[name] {
+		return nil
+	}
+	if err := b.addDir(filepath.Dir(name)); err != nil {
+		return err
+	}
+	b.written[name] = true
+	return b.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     name,
+		Linkname: b.redact.String(target),
+		Mode:     0777,
+		ModTime:  b.modTime,
+	})
+}
+
+// addJSON : add a generated JSON document
+func (b *bundleWriter) addJSON(name string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return b.addFile(name, content, 0644)
+}
+
+// readAttr : read a sysfs attribute, at most captureMaxFileBytes of it
+func readAttr(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(io.LimitReader(f, captureMaxFileBytes))
+}
+
+// skipAttr : attributes and directories that are never captured
+func skipAttr(name string) bool {
+	return captureSkipNames[name] || strings.HasPrefix(name, "resource")
+}
+
+// captureEntry : add one file or symlink; recurse into directories when deep is set
+func (b *bundleWriter) captureEntry(path string, info os.FileInfo, deep bool) error {
+	name := bundleName(path)
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil
+		}
+		return b.addSymlink(name, target)
+	case info.IsDir():
+		if deep {
+			return b.captureTree(path)
+		}
+		return nil
+	case info.Mode().Perm()&0444 == 0:
+		// write only attributes such as delete or rescan
+		return nil
+	default:
+		content, err := readAttr(path)
+		if err != nil {
+			// some attributes fail to read on devices that don't implement them
+			return nil
+		}
+		return b.addFile(name, content, int64(info.Mode().Perm()))
+	}
+}
+
+// captureTree : add a sysfs directory and everything below it
+func (b *bundleWriter) captureTree(dir string) error {
+	if b.visited[dir] {
+		return nil
+	}
+	b.visited[dir] = true
+	if err := b.addDir(bundleName(dir)); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		if skipAttr(entry.Name()) {
+			continue
+		}
+		if err := b.captureEntry(filepath.Join(dir, entry.Name()), entry, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// captureAncestors : add the attributes (not the subtrees) of every parent of a device
+func (b *bundleWriter) captureAncestors(dir string) error {
+	for parent := filepath.Dir(dir); parent != "/sys/devices" && parent != "/sys" && parent != "/"; parent = filepath.Dir(parent) {
+		if b.visited[parent] {
+			continue
+		}
+		entries, err := ioutil.ReadDir(parent)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if skipAttr(entry.Name()) {
+				continue
+			}
+			path := filepath.Join(parent, entry.Name())
+			// SAS link rates live under the phys of the port the disk hangs off
+			deep := strings.HasPrefix(filepath.Base(parent), "port-") && strings.HasPrefix(entry.Name(), "phy-")
+			if err := b.captureEntry(path, entry, deep); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// captureLinkDir : add a directory of device symlinks and the devices they point to
+func (b *bundleWriter) captureLinkDir(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		// not every host has every class
+		return nil
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if err := b.captureEntry(path, entry, true); err != nil {
+			return err
+		}
+		if entry.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			continue
+		}
+		if err := b.captureTree(target); err != nil {
+			return err
+		}
+		if err := b.captureAncestors(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// captureUdev : add the udev database entries of every block device
+func (b *bundleWriter) captureUdev() error {
+	entries, _ := ioutil.ReadDir("/sys/class/block")
+	for _, entry := range entries {
+		majMin, err := readFile("/sys/class/block/" + entry.Name() + "/dev")
+		if err != nil {
+			continue
+		}
+		path := "/run/udev/data/b" + majMin
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if err := b.addFile(bundleName(path), content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// captureDevNodes : add empty placeholders for the device nodes pass-through commands were sent to
+func (b *bundleWriter) captureDevNodes(disks []string) error {
+	var names []string
+	for _, devPath := range disks {
+		devName, _ := extractDev(devPath)
+		names = append(names, devName)
+	}
+	for devName := range passThroughLog {
+		names = append(names, devName)
+	}
+	for _, devName := range names {
+		if err := b.addFile(bundleName("/dev/"+devName), nil, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerCaptureValues : note everything identifying in the inventory and the udev database before writing
+func registerCaptureValues(found []disk) {
+	for i := range found {
+		activeRedactor.registerDisk(&found[i])
+	}
+	entries, _ := ioutil.ReadDir("/sys/class/block")
+	for _, entry := range entries {
+		if majMin, err := readFile("/sys/class/block/" + entry.Name() + "/dev"); err == nil {
+			content, _ := readFile("/run/udev/data/b" + majMin)
+			activeRedactor.registerUdev(content)
+		}
+	}
+}
+
+// redactPassThrough : mask identifying values inside the recorded raw responses
+func redactPassThrough(log map[string]map[string]passThroughRecord) map[string]map[string]passThroughRecord {
+	out := make(map[string]map[string]passThroughRecord)
+	for dev, commands := range log {
+		out[dev] = make(map[string]passThroughRecord)
+		for command, record := range commands {
+			out[dev][command] = passThroughRecord{
+				Data:  activeRedactor.Bytes(record.Data),
+				Error: activeRedactor.String(record.Error),
+			}
+		}
+	}
+	return out
+}
+
+// writeCapture : run the inventory with recording enabled and write the bundle
+func writeCapture(out io.Writer) (int, error) {
+	recorder := newRecordingBackend(backend)
+	backend = recorder
+	passThroughLog = make(map[string]map[string]passThroughRecord)
+	recordingPassThrough = true
+	defer func() { recordingPassThrough = false }()
+
+	disks, _ := backend.list()
+	var found []disk
+	for _, devPath := range disks {
+		var disk disk
+		_ = getDiskInfo(devPath, &disk)
+		found = append(found, disk)
+	}
+	recordingPassThrough = false
+
+	hostname, _ := os.Hostname()
+	kernel, _ := readFile("/proc/sys/kernel/osrelease")
+	manifest := captureManifest{
+		Tool:      "localdisk",
+		Version:   version,
+		Timestamp: time.Now().UTC(),
+		Hostname:  hostname,
+		Kernel:    kernel,
+		Backend:   recorder.name(),
+		Disks:     disks,
+	}
+	records := passThroughLog
+	if activeRedactor != nil {
+		registerCaptureValues(found)
+		manifest.Hostname = activeRedactor.hostName(manifest.Hostname)
+		manifest.Redacted = "reversible"
+		if activeRedactor.oneWay {
+			manifest.Redacted = "oneway"
+		}
+		records = redactPassThrough(passThroughLog)
+	}
+
+	gz := gzip.NewWriter(out)
+	b := &bundleWriter{
+		tw:      tar.NewWriter(gz),
+		modTime: manifest.Timestamp.Truncate(time.Second),
+		written: make(map[string]bool),
+		visited: make(map[string]bool),
+		redact:  activeRedactor,
+	}
+	steps := []func() error{
+		func() error { return b.addJSON(bundleManifest, manifest) },
+		func() error { return b.addJSON(bundleBackend, recorder.inventory) },
+		func() error { return b.addJSON(bundlePassThrough, records) },
+		func() error {
+			for _, dir := range captureLinkDirs {
+				if err := b.captureLinkDir(dir); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		b.captureUdev,
+		func() error {
+			for _, path := range captureProcFiles {
+				if content, err := ioutil.ReadFile(path); err == nil {
+					if err := b.addFile(bundleName(path), content, 0444); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		func() error { return b.captureDevNodes(disks) },
+		b.tw.Close,
+		gz.Close,
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return 0, err
+		}
+	}
+	return len(disks), nil
+}
+
+// captureCommand : write a capture bundle of this host for later offline analysis
+func captureCommand(args []string) int {
+	fs := flag.NewFlagSet("capture", flag.ExitOnError)
+	output := fs.String("o", "", "write the bundle to this file (.tar.gz)")
+	addBackendFlag(fs)
+	addRedactFlags(fs)
+	fs.Parse(args)
+
+	if *output == "" {
+		fmt.Println("An output file is required (-o node1.tar.gz)")
+		return 1
+	}
+	if err := initBackend(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := initRedactor(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Println("Unable to create " + *output + ": " + err.Error())
+		return 1
+	}
+	count, err := writeCapture(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*output)
+		fmt.Println("Unable to write capture bundle: " + err.Error())
+		return 1
+	}
+	if err := saveRedactor(); err != nil {
+		fmt.Println("Unable to save the redaction file " + redactFile + ": " + err.Error())
+		return 1
+	}
+	fmt.Printf("Captured %d disks to %s\n", count, *output)
+	fmt.Printf("Replay with: mkdir node && tar -xzf %s -C node && localdisk -root node -list\n", *output)
+	return 0
+}
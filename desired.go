@@ -0,0 +1,134 @@
+package main
+
+// Desired LED state. Enclosure and BMC resets and reboots lose LED state, so
+// every LED set through setFailLed or setIdentLed is also recorded in
+// -state-dir against the disk's WWID or serial number (device names change
+// across reboots). `led restore` puts the LEDs back from that record.
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const desiredStateFile = "desired-leds.json"
+
+type desiredLeds struct {
+	Wwid    string    `json:"wwid,omitempty"`
+	Serial  string    `json:"serial,omitempty"`
+	Device  string    `json:"device"`
+	Ident   string    `json:"ident,omitempty"`
+	Fault   string    `json:"fault,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// diskIdentity : the WWID and serial number of a disk, from sysfs when the backend can't tell
+func diskIdentity(devPath string) (string, string) {
+	wwid, _ := backend.vpd83(devPath)
+	serial, _ := backend.serialNum(devPath)
+	if wwid == "" {
+		wwid, _ = sysfsBackend{}.vpd83(devPath)
+	}
+	if serial == "" {
+		serial, _ = sysfsBackend{}.serialNum(devPath)
+	}
+	return wwid, serial
+}
+
+// desiredKey : the stable identity a disk's desired LED state is kept under
+func desiredKey(wwid string, serial string) string {
+	if wwid != "" {
+		return "wwid:" + wwid
+	}
+	if serial != "" {
+		return "serial:" + serial
+	}
+	return ""
+}
+
+// recordDesiredLed : remember the state a LED was set to
+func recordDesiredLed(devPath string, led string, state string) error {
+	wwid, serial := diskIdentity(devPath)
+	key := desiredKey(wwid, serial)
+	if key == "" {
+		return errors.New("no WWID or serial number to record the LED state of " + devPath + " under")
+	}
+
+	desired := make(map[string]*desiredLeds)
+	return updateState(desiredStateFile, &desired, func() error {
+		entry, ok := desired[key]
+		if !ok {
+			entry = &desiredLeds{}
+			desired[key] = entry
+		}
+		entry.Wwid, entry.Serial, entry.Device = wwid, serial, devPath
+		switch led {
+		case "ident":
+			entry.Ident = state
+		case "fault":
+			entry.Fault = state
+		}
+		entry.Updated = time.Now()
+		return nil
+	})
+}
+
+// findDesiredDisk : the current device of a recorded disk
+func findDesiredDisk(entry *desiredLeds) (string, error) {
+	if entry.Wwid != "" {
+		if devPath, err := resolveDisk(entry.Wwid); err == nil {
+			return devPath, nil
+		}
+	}
+	if entry.Serial != "" {
+		return resolveDisk(entry.Serial)
+	}
+	return "", errors.New("No local disk matches " + entry.Wwid)
+}
+
+// restoreCommand : set every recorded LED back to its desired state, returning the exit code
+func restoreCommand() int {
+	if !liveHost() {
+		fmt.Println("LEDs can only be changed on the live host")
+		return 1
+	}
+	desired := make(map[string]*desiredLeds)
+	if err := loadState(desiredStateFile, &desired); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if len(desired) == 0 {
+		fmt.Println("No desired LED state recorded")
+		return 0
+	}
+
+	var keys []string
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var changes []ledChange
+	for _, key := range keys {
+		entry := desired[key]
+		devPath, err := findDesiredDisk(entry)
+		if err != nil {
+			// the disk was pulled or replaced; its record stays for when it comes back
+			if outputFormat != "json" {
+				fmt.Printf("%s (last seen as %s): not present, skipped\n", key, entry.Device)
+			}
+			continue
+		}
+		for _, led := range ledNames {
+			state := entry.Ident
+			if led == "fault" {
+				state = entry.Fault
+			}
+			if state != "" {
+				changes = append(changes, changeLed(devPath, led, state))
+			}
+		}
+	}
+	return reportLedChanges(changes)
+}
@@ -0,0 +1,165 @@
+package main
+
+// List filters of the form "<field><op><value>", e.g. -where 'temp>50,health!=Good'.
+// Every comma separated condition must match for a disk to be selected.
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var filterOps = []string{">=", "<=", "!=", "==", "=", ">", "<", "~"}
+
+var filterFields = map[string]func(d *disk) string{
+	"path":      func(d *disk) string { return d.devPath },
+	"type":      func(d *disk) string { return d.devType },
+	"serial":    func(d *disk) string { return d.serialNumber },
+	"size":      func(d *disk) string { return strconv.FormatInt(d.sizeBytes, 10) },
+	"sector":    func(d *disk) string { return d.sectorFormat },
+	"transport": func(d *disk) string { return d.transport },
+	"rpm":       func(d *disk) string { return d.rpmText() },
+	"speed":     func(d *disk) string { return d.linkSpeedText() },
+	"ident":     func(d *disk) string { return d.ledIdent },
+	"fail":      func(d *disk) string { return d.ledFail },
+	"pattern":   func(d *disk) string { return d.ledPattern },
+	"health":    func(d *disk) string { return d.health },
+	"vendor":    func(d *disk) string { return d.vendor },
+	"model":     func(d *disk) string { return d.model },
+	"revision":  func(d *disk) string { return d.revision },
+	"wwid":      func(d *disk) string { return d.wwid },
+	"vpd83":     func(d *disk) string { return d.vpd83 },
+	"temp": func(d *disk) string {
+		if d.temperature == nil {
+			return ""
+		}
+		return strconv.FormatFloat(d.temperature.Current, 'f', -1, 64)
+	},
+}
+
+type diskFilter struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+var conditionStart = regexp.MustCompile(`^\s*([A-Za-z_]+)\s*(>=|<=|!=|==|=|>|<|~)`)
+
+// splitConditions : split a filter expression at the commas that start a new field<op> condition,
+// so commas inside values such as model~^(A{1,3}) stay where they are
+func splitConditions(expr string) []string {
+	var conds []string
+	start := 0
+	for i := 0; i < len(expr); i++ {
+		if expr[i] != ',' {
+			continue
+		}
+		// an empty condition ends here too, as in a,,b or a trailing comma
+		rest := strings.TrimSpace(expr[i+1:])
+		if rest != "" && !strings.HasPrefix(rest, ",") {
+			m := conditionStart.FindStringSubmatch(rest)
+			if m == nil {
+				continue
+			}
+			if _, ok := filterFields[strings.ToLower(m[1])]; !ok {
+				continue
+			}
+		}
+		conds = append(conds, expr[start:i])
+		start = i + 1
+	}
+	return append(conds, expr[start:])
+}
+
+// parseFilters : parse a comma separated list of filter conditions
+func parseFilters(expr string) ([]diskFilter, error) {
+	var filters []diskFilter
+
+	for _, cond := range splitConditions(expr) {
+		cond = strings.TrimSpace(cond)
+		if cond == "" {
+			continue
+		}
+		// split at the leftmost operator, the longest one where several start there (>= over >)
+		var filter *diskFilter
+		opIdx := -1
+		for _, op := range filterOps {
+			idx := strings.Index(cond, op)
+			if idx <= 0 || (opIdx >= 0 && (idx > opIdx || (idx == opIdx && len(op) <= len(filter.op)))) {
+				continue
+			}
+			opIdx = idx
+			filter = &diskFilter{
+				field: strings.ToLower(strings.TrimSpace(cond[:idx])),
+				op:    op,
+				value: strings.TrimSpace(cond[idx+len(op):]),
+			}
+		}
+		if filter == nil {
+			return nil, errors.New("Invalid filter condition " + cond)
+		}
+		if _, ok := filterFields[filter.field]; !ok {
+			return nil, errors.New("Unknown filter field " + filter.field)
+		}
+		if filter.op == "~" {
+			re, err := regexp.Compile(filter.value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid pattern in filter %s: %v", cond, err)
+			}
+			filter.re = re
+		}
+		filters = append(filters, *filter)
+	}
+	return filters, nil
+}
+
+// match : check a single condition against a disk
+func (f diskFilter) match(d *disk) bool {
+	actual := filterFields[f.field](d)
+
+	if f.re != nil {
+		return f.re.MatchString(actual)
+	}
+
+	a, errA := strconv.ParseFloat(actual, 64)
+	b, errB := strconv.ParseFloat(f.value, 64)
+	if errA == nil && errB == nil {
+		switch f.op {
+		case ">":
+			return a > b
+		case ">=":
+			return a >= b
+		case "<":
+			return a < b
+		case "<=":
+			return a <= b
+		case "!=":
+			return a != b
+		default:
+			return a == b
+		}
+	}
+
+	switch f.op {
+	case "=", "==":
+		return strings.EqualFold(actual, f.value)
+	case "!=":
+		return !strings.EqualFold(actual, f.value)
+	default:
+		// ordering comparisons need numbers on both sides; unknown values never match
+		return false
+	}
+}
+
+// matchFilters : check that a disk satisfies every condition
+func matchFilters(d *disk, filters []diskFilter) bool {
+	for _, f := range filters {
+		if !f.match(d) {
+			return false
+		}
+	}
+	return true
+}
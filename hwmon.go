@@ -0,0 +1,92 @@
+package main
+
+// Drive temperatures from the hwmon class. The drivetemp driver registers
+// its hwmon device under the SCSI device of the disk and the nvme driver
+// under the NVMe controller, so a disk is matched to its sensor by comparing
+// the resolved "device" links.
+
+import (
+	"path/filepath"
+	"strconv"
+)
+
+type diskTemperature struct {
+	Source   string   `json:"source"`
+	Current  float64  `json:"current_celsius"`
+	Min      *float64 `json:"min_celsius,omitempty"`
+	Max      *float64 `json:"max_celsius,omitempty"`
+	Critical *float64 `json:"critical_celsius,omitempty"`
+}
+
+// readMilliCelsius : read a hwmon temperature attribute, returning nil when it is not provided
+func readMilliCelsius(path string) *float64 {
+	content, err := readFile(path)
+	if err != nil {
+		return nil
+	}
+	milli, err := strconv.ParseInt(content, 10, 64)
+	if err != nil {
+		return nil
+	}
+	celsius := float64(milli) / 1000
+	return &celsius
+}
+
+// hwmonDevices : the sysfs devices a disk's hwmon sensor may be registered under
+func hwmonDevices(devPath string) map[string]bool {
+	devices := make(map[string]bool)
+
+	devName, _ := extractDev(devPath)
+	if link, err := filepath.EvalSymlinks(hostPath("/sys/class/block/" + devName + "/device")); err == nil {
+		devices[link] = true
+	}
+	if ctrlPath, err := nvmeControllerPath(devPath); err == nil {
+		ctrl, _ := extractDev(ctrlPath)
+		if link, err := filepath.EvalSymlinks(hostPath("/sys/class/nvme/" + ctrl)); err == nil {
+			devices[link] = true
+		}
+	}
+	return devices
+}
+
+// hwmonTempGet : find the hwmon sensor linked to a disk and read its temperatures
+func hwmonTempGet(devPath string) *diskTemperature {
+	devices := hwmonDevices(devPath)
+
+	hwmons, _ := filepath.Glob(hostPath("/sys/class/hwmon/hwmon*"))
+	for _, hwmon := range hwmons {
+		link, err := filepath.EvalSymlinks(hwmon + "/device")
+		if err != nil || !devices[link] {
+			continue
+		}
+		current := readMilliCelsius(hwmon + "/temp1_input")
+		if current == nil {
+			continue
+		}
+		name, _ := readFile(hwmon + "/name")
+		return &diskTemperature{
+			Source:   "hwmon/" + name,
+			Current:  *current,
+			Min:      readMilliCelsius(hwmon + "/temp1_min"),
+			Max:      readMilliCelsius(hwmon + "/temp1_max"),
+			Critical: readMilliCelsius(hwmon + "/temp1_crit"),
+		}
+	}
+	return nil
+}
+
+// passThroughTemp : fall back on a temperature already collected through pass-through commands
+func passThroughTemp(disk *disk) *diskTemperature {
+	if disk.nvmeHealth != nil {
+		return &diskTemperature{Source: "nvme", Current: float64(disk.nvmeHealth.TemperatureCelsius)}
+	}
+	if disk.scsiLogs != nil && disk.scsiLogs.Temperature != nil && disk.scsiLogs.Temperature.Current >= 0 {
+		return &diskTemperature{Source: "scsi", Current: float64(disk.scsiLogs.Temperature.Current)}
+	}
+	for _, attr := range disk.smart {
+		if attr.ID == 194 || attr.ID == 190 {
+			return &diskTemperature{Source: "smart", Current: float64(attr.displayRaw())}
+		}
+	}
+	return nil
+}
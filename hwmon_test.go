@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const (
+	sataDevice = "sys/devices/pci0000:00/0000:00:17.0/ata1/host0/target0:0:0/0:0:0:0"
+	nvmeDevice = "sys/devices/pci0000:00/0000:00:1d.0/0000:3d:00.0/nvme/nvme0"
+)
+
+// a SATA disk with a drivetemp sensor, an NVMe controller and a sensor belonging to neither
+var hwmonFixture = map[string]string{
+	sataDevice + "/hwmon/hwmon0/name":                          "drivetemp\n",
+	sataDevice + "/hwmon/hwmon0/temp1_input":                   "38000\n",
+	sataDevice + "/hwmon/hwmon0/temp1_min":                     "0\n",
+	sataDevice + "/hwmon/hwmon0/temp1_max":                     "60000\n",
+	sataDevice + "/hwmon/hwmon0/temp1_crit":                    "70500\n",
+	nvmeDevice + "/hwmon1/name":                                "nvme\n",
+	nvmeDevice + "/hwmon1/temp1_input":                         "41850\n",
+	nvmeDevice + "/hwmon1/temp1_crit":                          "84850\n",
+	"sys/devices/platform/coretemp.0/hwmon/hwmon2/name":        "coretemp\n",
+	"sys/devices/platform/coretemp.0/hwmon/hwmon2/temp1_input": "52000\n",
+}
+
+// the links the kernel makes between the class directories and the devices
+var hwmonLinks = map[string]string{
+	"sys/class/block/sda/device":                          sataDevice,
+	"sys/class/block/nvme0n1/device":                      nvmeDevice,
+	"sys/class/nvme/nvme0":                                nvmeDevice,
+	"sys/class/hwmon/hwmon0":                              sataDevice + "/hwmon/hwmon0",
+	"sys/class/hwmon/hwmon1":                              nvmeDevice + "/hwmon1",
+	"sys/class/hwmon/hwmon2":                              "sys/devices/platform/coretemp.0/hwmon/hwmon2",
+	sataDevice + "/hwmon/hwmon0/device":                   sataDevice,
+	nvmeDevice + "/hwmon1/device":                         nvmeDevice,
+	"sys/devices/platform/coretemp.0/hwmon/hwmon2/device": "sys/devices/platform/coretemp.0",
+}
+
+// celsius : a pointer to a temperature, as the optional hwmon attributes are returned
+func celsius(v float64) *float64 {
+	return &v
+}
+
+var hwmonTempTests = []struct {
+	devPath string
+	want    *diskTemperature
+}{
+	{"/dev/sda", &diskTemperature{Source: "hwmon/drivetemp", Current: 38, Min: celsius(0), Max: celsius(60), Critical: celsius(70.5)}},
+	{"/dev/nvme0n1", &diskTemperature{Source: "hwmon/nvme", Current: 41.85, Critical: celsius(84.85)}},
+	{"/dev/sdb", nil},
+}
+
+func TestHwmonTempGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localdisk-hwmon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFixture(t, dir, hwmonFixture)
+	for link, target := range hwmonLinks {
+		path := filepath.Join(dir, link)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Symlink(filepath.Join(dir, target), path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(sys, dev string) { sysfsRoot, devRoot = sys, dev }(sysfsRoot, devRoot)
+	sysfsRoot, devRoot = filepath.Join(dir, "sys"), filepath.Join(dir, "dev")
+
+	for _, test := range hwmonTempTests {
+		if got := hwmonTempGet(test.devPath); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("hwmonTempGet(%s) = %+v, want %+v", test.devPath, got, test.want)
+		}
+	}
+}
+
+func TestPassThroughTemp(t *testing.T) {
+	tests := []struct {
+		name string
+		disk disk
+		want *diskTemperature
+	}{
+		{"nvme health log", disk{nvmeHealth: &nvmeHealthLog{TemperatureCelsius: 44}},
+			&diskTemperature{Source: "nvme", Current: 44}},
+		{"scsi temperature page", disk{scsiLogs: &scsiLogPages{Temperature: &scsiTemperaturePage{Current: 35, Reference: 65}}},
+			&diskTemperature{Source: "scsi", Current: 35}},
+		{"scsi sensor not available", disk{scsiLogs: &scsiLogPages{Temperature: &scsiTemperaturePage{Current: -1, Reference: -1}}},
+			nil},
+		{"smart attribute", disk{smart: []smartAttribute{{ID: 9, Raw: 1000}, {ID: 194, Raw: 0x3700120024}}},
+			&diskTemperature{Source: "smart", Current: 36}},
+		{"nothing collected", disk{}, nil},
+	}
+	for _, test := range tests {
+		if got := passThroughTemp(&test.disk); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: passThroughTemp = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+// Disk LED control. Every change reads the LED status back from the backend
+// afterwards, because enclosures and HBAs accept requests they then ignore:
+// the report says whether the LED really changed and which LED states the
+// slot can show.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	lsm "github.com/libstorage/libstoragemgmt-golang"
+)
+
+// ledBits : the status bits describing one LED
+type ledBits struct {
+	on      lsm.DiskLedStatusBitField
+	off     lsm.DiskLedStatusBitField
+	unknown lsm.DiskLedStatusBitField
+}
+
+var ledNames = []string{"ident", "fault"}
+
+var ledStatusBits = map[string]ledBits{
+	"ident": {lsm.DiskLedStatusIdentOn, lsm.DiskLedStatusIdentOff, lsm.DiskLedStatusIdentUnknown},
+	"fault": {lsm.DiskLedStatusFaultOn, lsm.DiskLedStatusFaultOff, lsm.DiskLedStatusFaultUnknown},
+}
+
+type ledChange struct {
+	Device    string   `json:"device"`
+	Led       string   `json:"led"`
+	Requested string   `json:"requested"`
+	Before    string   `json:"before"`
+	After     string   `json:"after"`
+	Changed   bool     `json:"changed"`
+	Mechanism string   `json:"mechanism,omitempty"`
+	Supported []string `json:"supported"`
+	Error     string   `json:"error,omitempty"`
+	// something that went wrong around a change without affecting its result
+	Warning string `json:"warning,omitempty"`
+}
+
+const (
+	ledOn          = "ON"
+	ledOff         = "OFF"
+	ledUnknown     = "UNKNOWN"
+	ledUnsupported = "UNSUPPORTED"
+)
+
+// ledState : the state of one LED in a status bit field
+//
+// The bits of a LED the slot doesn't have are all clear. DiskLedStatusUnknown
+// means nothing could be read, and a LED with both its on and off bits set is
+// reported unknown rather than guessed.
+func ledState(status lsm.DiskLedStatusBitField, led string) string {
+	bits := ledStatusBits[led]
+	if status&lsm.DiskLedStatusUnknown != 0 {
+		return ledUnknown
+	}
+	switch status & (bits.on | bits.off | bits.unknown) {
+	case 0:
+		return ledUnsupported
+	case bits.on:
+		return ledOn
+	case bits.off:
+		return ledOff
+	default:
+		return ledUnknown
+	}
+}
+
+// ledStatusFlags : the names of the bits set in a status bit field
+func ledStatusFlags(status lsm.DiskLedStatusBitField) []string {
+	flags := []string{}
+	for _, bit := range []struct {
+		bit  lsm.DiskLedStatusBitField
+		name string
+	}{
+		{lsm.DiskLedStatusUnknown, "unknown"},
+		{lsm.DiskLedStatusIdentOn, "ident_on"},
+		{lsm.DiskLedStatusIdentOff, "ident_off"},
+		{lsm.DiskLedStatusIdentUnknown, "ident_unknown"},
+		{lsm.DiskLedStatusFaultOn, "fault_on"},
+		{lsm.DiskLedStatusFaultOff, "fault_off"},
+		{lsm.DiskLedStatusFaultUnknown, "fault_unknown"},
+	} {
+		if status&bit.bit != 0 {
+			flags = append(flags, bit.name)
+		}
+	}
+	return flags
+}
+
+// supportedLedStates : the LED states a slot reports it can show
+func supportedLedStates(status lsm.DiskLedStatusBitField) []string {
+	supported := []string{}
+	if status&lsm.DiskLedStatusUnknown != 0 {
+		return supported
+	}
+	for _, led := range ledNames {
+		if ledState(status, led) != ledUnsupported {
+			supported = append(supported, led+" on", led+" off")
+		}
+	}
+	return supported
+}
+
+// setLed : switch one of a disk's LEDs on or off, returning how it was set
+func setLed(devPath string, led string, state string) (string, error) {
+	switch led {
+	case "fault":
+		return setFailLed(devPath, state)
+	case "ident":
+		return setIdentLed(devPath, state)
+	default:
+		return "", errors.New("Unknown LED " + led)
+	}
+}
+
+// changeLed : set a LED under the disk's lock and report the change, recording it in the audit log
+func changeLed(devPath string, led string, state string) ledChange {
+	if liveHost() {
+		unlock, err := lockDisk(devPath)
+		if err != nil {
+			return auditChange(ledChange{Device: devPath, Led: led, Requested: strings.ToUpper(state), Supported: []string{}, Error: err.Error()})
+		}
+		defer unlock()
+	}
+	return auditChange(switchLed(devPath, led, state))
+}
+
+// switchLed : set a LED and read it back to see whether it took effect
+func switchLed(devPath string, led string, state string) ledChange {
+	change := ledChange{Device: devPath, Led: led, Requested: strings.ToUpper(state), Supported: []string{}}
+
+	before, beforeErr := diskLedStatus(devPath)
+	change.Before = ledState(before, led)
+	if beforeErr != nil {
+		change.Before = ledUnknown
+	}
+
+	mechanism, err := setLed(devPath, led, state)
+	change.Mechanism = mechanism
+	if err != nil {
+		change.After = change.Before
+		change.Supported = supportedLedStates(before)
+		change.Error = err.Error()
+		return change
+	}
+
+	after, err := diskLedStatus(devPath)
+	if err != nil {
+		change.After = ledUnknown
+		// RAID plugins set volume LEDs they can't report; anywhere else it means the change can't be confirmed
+		if !isRaidPlugin(change.Mechanism) {
+			change.Error = "Unable to read the LED status back: " + err.Error()
+		}
+		return change
+	}
+	change.After = ledState(after, led)
+	change.Changed = beforeErr == nil && change.Before != change.After
+	change.Supported = supportedLedStates(after)
+	return change
+}
+
+// printLedChange : report a LED change on one line, returning false when it failed
+func printLedChange(change ledChange) bool {
+	if outputFormat == "json" {
+		printJSON(change)
+		return change.Error == ""
+	}
+	fmt.Println(ledChangeText(change))
+	return change.Error == ""
+}
+
+// ledChangeText : the result line of a LED change
+func ledChangeText(change ledChange) string {
+	text := ledResultText(change)
+	if change.Warning != "" {
+		text += " (warning: " + change.Warning + ")"
+	}
+	return text
+}
+
+// ledResultText : the outcome of a LED change for display
+func ledResultText(change ledChange) string {
+	if change.Error != "" {
+		if change.Led == "" {
+			return change.Device + ": " + change.Error
+		}
+		return fmt.Sprintf("%s %s LED: unable to switch %s: %s", change.Device, change.Led, strings.ToLower(change.Requested), change.Error)
+	}
+	result := "unchanged"
+	if change.Changed {
+		result = "changed"
+	}
+	switch {
+	case change.After == ledUnknown:
+		result = "set, its state can't be read back"
+	case change.After != change.Requested:
+		result += ", the LED did not take the requested state"
+	}
+	if change.Mechanism != "" {
+		result += " via " + change.Mechanism
+	}
+	return fmt.Sprintf("%s %s LED: %s -> %s (%s), supported: %s", change.Device, change.Led, change.Before, change.After, result, supportedText(change.Supported))
+}
+
+// supportedText : the supported LED states for display
+func supportedText(supported []string) string {
+	if len(supported) == 0 {
+		return "none reported"
+	}
+	return strings.Join(supported, ", ")
+}
+
+// ledStatusCommand : print the LED states of a disk
+func ledStatusCommand(devPath string) int {
+	status, err := diskLedStatus(devPath)
+	if err != nil {
+		fmt.Println("Unable to read the LED status of " + devPath + ": " + err.Error())
+		return 1
+	}
+	pattern, _ := sesPattern(devPath)
+
+	if outputFormat == "json" {
+		printJSON(struct {
+			Device      string                    `json:"device"`
+			Ident       string                    `json:"ident"`
+			Fault       string                    `json:"fault"`
+			Status      lsm.DiskLedStatusBitField `json:"led_status"`
+			StatusFlags []string                  `json:"led_status_flags"`
+			Supported   []string                  `json:"supported"`
+			Pattern     string                    `json:"pattern,omitempty"`
+		}{devPath, ledState(status, "ident"), ledState(status, "fault"), status, ledStatusFlags(status), supportedLedStates(status), pattern})
+		return 0
+	}
+	fmt.Printf("Device Path    : %s\n", devPath)
+	fmt.Printf("IDENT LED      : %s\n", ledState(status, "ident"))
+	fmt.Printf("FAIL LED       : %s\n", ledState(status, "fault"))
+	fmt.Printf("Supported LEDs : %s\n", supportedText(supportedLedStates(status)))
+	if pattern != "" {
+		fmt.Printf("LED Pattern    : %s\n", pattern)
+	}
+	return 0
+}
+
+// ledUsage : describe the led subcommand
+func ledUsage(fs *flag.FlagSet) {
+	fmt.Println("Usage: localdisk led [flags] ident|fault|all on|off <disk>|-where <conditions>|-serials-file <file>|-all-disks")
+	fmt.Println("       localdisk led [flags] locate -for <duration> <disk>|-where <conditions>|-serials-file <file>|-all-disks")
+	fmt.Println("       localdisk led [flags] set -pattern rebuild|hotspare|pfa|ica|ifa|none <disk>|-where <conditions>|-serials-file <file>|-all-disks")
+	fmt.Println("       localdisk led [flags] status <disk>")
+	fmt.Println("       localdisk led [flags] cleanup [-all]")
+	fmt.Println("       localdisk led [flags] restore")
+	fmt.Println("A disk is a device, device name, serial number or WWID.")
+	fs.PrintDefaults()
+}
+
+// ledCommand : the led subcommand, returning the exit code
+func ledCommand(args []string) int {
+	fs := flag.NewFlagSet("led", flag.ExitOnError)
+	fs.StringVar(&outputFormat, "output", "text", "output format (text or json)")
+	locateFor := fs.Duration("for", 0, "with locate, how long to light the LED, e.g. 20m")
+	cleanupAll := fs.Bool("all", false, "with cleanup, switch off every locate LED, expired or not")
+	pattern := fs.String("pattern", "", "with set, the IBPI pattern the enclosure shows for the disk")
+	addBackendFlag(fs)
+	addRootFlags(fs)
+	addStateDirFlag(fs)
+	addAuditLogFlag(fs)
+	addLockFlags(fs)
+	addLedSelectFlags(fs)
+	fs.Usage = func() { ledUsage(fs) }
+
+	// flags may come anywhere, as in led locate -for 20m sda or led all off -all-disks
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	var action string
+	var rest []string
+	if len(positional) > 0 {
+		action, rest = positional[0], positional[1:]
+	}
+
+	if outputFormat != "text" && outputFormat != "json" {
+		fmt.Println("Unsupported output format " + outputFormat)
+		return 1
+	}
+	if err := applyRootFlags(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := initBackend(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	// every run that can touch LEDs first switches off the ones whose timers ran out
+	if action != "status" && liveHost() {
+		failed, err := expireLocateTimers(action == "cleanup" && *cleanupAll)
+		if err != nil {
+			fmt.Println("Unable to check the LED timers: " + err.Error())
+			if action == "cleanup" {
+				return 1
+			}
+		}
+		if action == "cleanup" {
+			if failed > 0 {
+				return 1
+			}
+			return 0
+		}
+	}
+
+	switch {
+	case action == "status" && len(rest) == 1:
+		devPath, err := resolveDisk(rest[0])
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		return ledStatusCommand(devPath)
+	case action == "locate":
+		return locateCommand(rest, *locateFor)
+	case action == "cleanup" && len(rest) == 0:
+		fmt.Println("LEDs can only be changed on the live host")
+		return 1
+	case action == "set" && *pattern != "":
+		return setPatternCommand(*pattern, rest)
+	case action == "restore" && len(rest) == 0:
+		return restoreCommand()
+	case (action == "ident" || action == "fault" || action == "all") && len(rest) >= 1:
+		return setLedsCommand(action, rest[0], rest[1:])
+	default:
+		ledUsage(fs)
+		return 1
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+
+	lsm "github.com/libstorage/libstoragemgmt-golang"
+)
+
+// the state of one LED for each combination of its on, off and unknown bits
+var ledStateTests = []struct {
+	on, off, unknown bool
+	want             string
+}{
+	{false, false, false, ledUnsupported},
+	{true, false, false, ledOn},
+	{false, true, false, ledOff},
+	{false, false, true, ledUnknown},
+	{true, true, false, ledUnknown},
+	{true, false, true, ledUnknown},
+	{false, true, true, ledUnknown},
+	{true, true, true, ledUnknown},
+}
+
+// ledBitsFor : the status bits of one LED test case
+func ledBitsFor(bits ledBits, on, off, unknown bool) lsm.DiskLedStatusBitField {
+	var status lsm.DiskLedStatusBitField
+	if on {
+		status |= bits.on
+	}
+	if off {
+		status |= bits.off
+	}
+	if unknown {
+		status |= bits.unknown
+	}
+	return status
+}
+
+func TestLedState(t *testing.T) {
+	for _, ident := range ledStateTests {
+		for _, fault := range ledStateTests {
+			for _, allUnknown := range []bool{false, true} {
+				status := ledBitsFor(ledStatusBits["ident"], ident.on, ident.off, ident.unknown) |
+					ledBitsFor(ledStatusBits["fault"], fault.on, fault.off, fault.unknown)
+				wantIdent, wantFault := ident.want, fault.want
+				if allUnknown {
+					status |= lsm.DiskLedStatusUnknown
+					wantIdent, wantFault = ledUnknown, ledUnknown
+				}
+
+				if got := ledState(status, "ident"); got != wantIdent {
+					t.Errorf("ledState(%#x, ident) = %s, want %s", uint32(status), got, wantIdent)
+				}
+				if got := ledState(status, "fault"); got != wantFault {
+					t.Errorf("ledState(%#x, fault) = %s, want %s", uint32(status), got, wantFault)
+				}
+			}
+		}
+	}
+}
+
+func TestLedStatusFlags(t *testing.T) {
+	tests := []struct {
+		status lsm.DiskLedStatusBitField
+		want   []string
+	}{
+		{0, []string{}},
+		{lsm.DiskLedStatusUnknown, []string{"unknown"}},
+		{lsm.DiskLedStatusIdentOn | lsm.DiskLedStatusFaultOff, []string{"ident_on", "fault_off"}},
+		{lsm.DiskLedStatusIdentUnknown | lsm.DiskLedStatusFaultOn, []string{"ident_unknown", "fault_on"}},
+		{lsm.DiskLedStatusIdentOff | lsm.DiskLedStatusFaultUnknown, []string{"ident_off", "fault_unknown"}},
+	}
+	for _, test := range tests {
+		got := ledStatusFlags(test.status)
+		if len(got) != len(test.want) {
+			t.Errorf("ledStatusFlags(%#x) = %v, want %v", uint32(test.status), got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("ledStatusFlags(%#x) = %v, want %v", uint32(test.status), got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSupportedLedStates(t *testing.T) {
+	tests := []struct {
+		status lsm.DiskLedStatusBitField
+		want   int
+	}{
+		{0, 0},
+		{lsm.DiskLedStatusUnknown | lsm.DiskLedStatusIdentOn, 0},
+		{lsm.DiskLedStatusFaultOff, 2},
+		{lsm.DiskLedStatusIdentUnknown | lsm.DiskLedStatusFaultOn, 4},
+	}
+	for _, test := range tests {
+		if got := supportedLedStates(test.status); len(got) != test.want {
+			t.Errorf("supportedLedStates(%#x) = %v, want %d states", uint32(test.status), got, test.want)
+		}
+	}
+}
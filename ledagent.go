@@ -0,0 +1,187 @@
+package main
+
+// led-agent: keep fault LEDs in line with disk health. Each pass lights the
+// fault LED of disks whose health (libstoragemgmt plus the SMART, SCSI log
+// page and NVMe health collectors) is Fail, and switches it off once a disk
+// with a different serial sits in the same slot. The agent only ever clears
+// LEDs it lit itself, recorded in -state-dir, so LEDs an operator set by hand
+// are left alone.
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const agentStateFile = "led-agent.json"
+
+// a fault LED the agent lit
+type agentFault struct {
+	Slot   string    `json:"slot"`
+	Device string    `json:"device"`
+	Serial string    `json:"serial"`
+	Wwid   string    `json:"wwid,omitempty"`
+	Health string    `json:"health"`
+	Since  time.Time `json:"since"`
+}
+
+// diskSlot : where a disk sits; the enclosure slot, else its udev by-path name, else empty, since
+// device names move between boots and hot-plugs and say nothing about whether a disk was replaced
+func diskSlot(devPath string) string {
+	if slot, err := enclosureComponent(devPath); err == nil {
+		if realPath, err := filepath.EvalSymlinks(slot); err == nil {
+			return realPath
+		}
+		return slot
+	}
+	if idPath := udevProperties(devPath)["ID_PATH"]; idPath != "" {
+		return "by-path/" + idPath
+	}
+	return ""
+}
+
+// knownSerial : the serial of a disk, from sysfs when the backend can't read it
+func knownSerial(d *disk) string {
+	_, serial := diskIdentity(d.devPath)
+	return serial
+}
+
+// agentLog : print a timestamped agent message
+func agentLog(format string, a ...interface{}) {
+	fmt.Printf("%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, a...))
+}
+
+// agentPass : reconcile the fault LEDs with disk health once, holding the state file's lock throughout
+func agentPass() error {
+	var faults []agentFault
+	return updateState(agentStateFile, &faults, func() error {
+		kept, err := reconcileFaults(faults)
+		faults = kept
+		return err
+	})
+}
+
+// reconcileFaults : light and clear fault LEDs, returning the ones the agent now owns
+func reconcileFaults(faults []agentFault) ([]agentFault, error) {
+	devPaths, err := backend.list()
+	if err != nil {
+		return nil, err
+	}
+
+	var disks []disk
+	bySlot := make(map[string]*disk)
+	slots := make(map[string]string)
+	for _, devPath := range devPaths {
+		var d disk
+		if err := getDiskInfo(devPath, &d); err != nil {
+			continue
+		}
+		disks = append(disks, d)
+	}
+	for i := range disks {
+		slot := diskSlot(disks[i].devPath)
+		if slot != "" {
+			bySlot[slot] = &disks[i]
+		}
+		slots[disks[i].devPath] = slot
+	}
+
+	// clear the LEDs of failed disks that have been replaced
+	var kept []agentFault
+	lit := make(map[string]bool)
+	for _, fault := range faults {
+		if strings.HasPrefix(fault.Slot, "/dev/") {
+			// recorded by a version that keyed on device names, which can't tell a replacement from a rename
+			agentLog("%s: forgetting its fault LED, the device name isn't a slot", fault.Slot)
+			continue
+		}
+		d, ok := bySlot[fault.Slot]
+		serial := ""
+		if ok {
+			serial = knownSerial(d)
+		}
+		// an empty slot keeps its LED so the technician can still find it
+		if serial == "" || serial == fault.Serial {
+			kept = append(kept, fault)
+			lit[fault.Slot] = true
+			continue
+		}
+		change := changeLed(d.devPath, "fault", "off")
+		if change.Error != "" {
+			agentLog("%s: replaced (serial %s, was %s) but %s", d.devPath, serial, fault.Serial, ledChangeText(change))
+			kept = append(kept, fault)
+			lit[fault.Slot] = true
+			continue
+		}
+		agentLog("%s: replaced (serial %s, was %s), %s", d.devPath, serial, fault.Serial, ledChangeText(change))
+	}
+
+	// light the LEDs of failing disks
+	for i := range disks {
+		d := &disks[i]
+		slot := slots[d.devPath]
+		if d.health != "Fail" || lit[slot] {
+			continue
+		}
+		status, err := diskLedStatus(d.devPath)
+		if err == nil && ledState(status, "fault") == ledOn {
+			// somebody else lit it, so it isn't ours to clear
+			continue
+		}
+		change := changeLed(d.devPath, "fault", "on")
+		agentLog("%s: health %s (serial %s), %s", d.devPath, d.health, d.serialNumber, ledChangeText(change))
+		// a LED that came on is the agent's even when something after setting it failed
+		if change.Error != "" && change.After != ledOn {
+			continue
+		}
+		if slot == "" {
+			// without a slot a replacement can't be told from a renamed device, so it is left lit
+			agentLog("%s: no enclosure slot or by-path name, its fault LED won't be cleared automatically", d.devPath)
+			continue
+		}
+		wwid, serial := diskIdentity(d.devPath)
+		kept = append(kept, agentFault{Slot: slot, Device: d.devPath, Serial: serial, Wwid: wwid, Health: d.health, Since: time.Now()})
+		lit[slot] = true
+	}
+
+	if kept == nil {
+		kept = []agentFault{}
+	}
+	return kept, nil
+}
+
+// ledAgentCommand : the led-agent subcommand, returning the exit code
+func ledAgentCommand(args []string) int {
+	fs := flag.NewFlagSet("led-agent", flag.ExitOnError)
+	interval := fs.Duration("interval", 5*time.Minute, "time between health checks")
+	once := fs.Bool("once", false, "check once and exit, e.g. from a systemd timer")
+	addBackendFlag(fs)
+	addStateDirFlag(fs)
+	addAuditLogFlag(fs)
+	addLockFlags(fs)
+	fs.Parse(args)
+
+	if *interval <= 0 {
+		fmt.Println("The interval must be positive")
+		return 1
+	}
+	if err := initBackend(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	for {
+		if err := agentPass(); err != nil {
+			agentLog("health check failed: %s", err.Error())
+			if *once {
+				return 1
+			}
+		}
+		if *once {
+			return 0
+		}
+		time.Sleep(*interval)
+	}
+}
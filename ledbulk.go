@@ -0,0 +1,196 @@
+package main
+
+// LED commands on many disks at once. Disks are selected with the same
+// conditions as `-list -where`, a file of serial numbers or WWIDs, or every
+// local disk; each disk gets its own result and any failure fails the run.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var ledWhere string
+var ledSerialsFile string
+var ledAllDisks bool
+
+// addLedSelectFlags : register the disk selection flags of the led subcommand
+func addLedSelectFlags(fs *flag.FlagSet) {
+	fs.StringVar(&ledWhere, "where", "", "act on every disk matching the -list conditions, e.g. 'health=Fail'")
+	fs.StringVar(&ledSerialsFile, "serials-file", "", "act on the disks whose serial numbers or WWIDs are listed in this file, one per line")
+	fs.BoolVar(&ledAllDisks, "all-disks", false, "act on every local disk")
+}
+
+// bulkSelection : true when the disks come from the selection flags rather than an argument
+func bulkSelection() bool {
+	return ledWhere != "" || ledSerialsFile != "" || ledAllDisks
+}
+
+// readSerialsFile : the serial numbers or WWIDs in a file, skipping blank lines and # comments
+func readSerialsFile(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var serials []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			serials = append(serials, line)
+		}
+	}
+	if len(serials) == 0 {
+		return nil, errors.New("No serial numbers found in " + path)
+	}
+	return serials, nil
+}
+
+// diskHasID : true when a serial number or WWID belongs to a disk
+func diskHasID(d *disk, id string) bool {
+	wanted := strings.ToLower(strings.TrimPrefix(id, "naa."))
+	for _, have := range []string{d.serialNumber, d.vpd83, d.wwid} {
+		if have != "" && strings.ToLower(strings.TrimPrefix(have, "naa.")) == wanted {
+			return true
+		}
+	}
+	return false
+}
+
+// selectDisks : the disks picked by the selection flags, and the listed serials no disk matched
+func selectDisks() ([]string, []string, error) {
+	filters, err := parseFilters(ledWhere)
+	if err != nil {
+		return nil, nil, err
+	}
+	var serials []string
+	if ledSerialsFile != "" {
+		if serials, err = readSerialsFile(ledSerialsFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	disks, err := backend.list()
+	if err != nil {
+		return nil, nil, err
+	}
+	var selected []string
+	matched := make(map[string]bool)
+	for _, devPath := range disks {
+		var d disk
+		_ = getDiskInfo(devPath, &d)
+		if !matchFilters(&d, filters) {
+			continue
+		}
+		if serials != nil {
+			listed := false
+			for _, serial := range serials {
+				if diskHasID(&d, serial) {
+					matched[serial] = true
+					listed = true
+				}
+			}
+			if !listed {
+				continue
+			}
+		}
+		selected = append(selected, devPath)
+	}
+
+	var missing []string
+	for _, serial := range serials {
+		if !matched[serial] {
+			missing = append(missing, serial)
+		}
+	}
+	return selected, missing, nil
+}
+
+// ledTargets : the disks a LED command acts on, either the one named or the selected ones;
+// listed serials without a disk come back as failed results
+func ledTargets(args []string) ([]string, []ledChange, error) {
+	if bulkSelection() {
+		if len(args) != 0 {
+			return nil, nil, errors.New("Give either a device or -where, -serials-file or -all-disks, not both")
+		}
+		disks, missing, err := selectDisks()
+		if err != nil {
+			return nil, nil, err
+		}
+		var failed []ledChange
+		for _, serial := range missing {
+			failed = append(failed, ledChange{Device: serial, Supported: []string{}, Error: "No local disk matches " + serial})
+		}
+		return disks, failed, nil
+	}
+	if len(args) != 1 {
+		return nil, nil, errors.New("Give a device, or select disks with -where, -serials-file or -all-disks")
+	}
+	devPath, err := resolveDisk(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return []string{devPath}, nil, nil
+}
+
+// reportLedChanges : print one result per disk and LED, returning the exit code
+func reportLedChanges(changes []ledChange) int {
+	failed := 0
+	for _, change := range changes {
+		if change.Error != "" {
+			failed++
+		}
+	}
+
+	if outputFormat == "json" {
+		if changes == nil {
+			changes = []ledChange{}
+		}
+		if !bulkSelection() && len(changes) == 1 {
+			printJSON(changes[0])
+		} else {
+			printJSON(changes)
+		}
+	} else {
+		for _, change := range changes {
+			fmt.Println(ledChangeText(change))
+		}
+		if len(changes) > 1 {
+			fmt.Printf("%d of %d LED changes failed\n", failed, len(changes))
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// setLedsCommand : switch one or both LEDs of the target disks, returning the exit code
+func setLedsCommand(led string, state string, args []string) int {
+	if state != "on" && state != "off" {
+		fmt.Println("Unknown LED state " + state + ", use on or off")
+		return 1
+	}
+	devPaths, changes, err := ledTargets(args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	if len(devPaths) == 0 && len(changes) == 0 {
+		fmt.Println("No local disks match the selection")
+		return 0
+	}
+
+	leds := []string{led}
+	if led == "all" {
+		leds = ledNames
+	}
+	for _, devPath := range devPaths {
+		for _, l := range leds {
+			changes = append(changes, changeLed(devPath, l, state))
+		}
+	}
+	return reportLedChanges(changes)
+}
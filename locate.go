@@ -0,0 +1,347 @@
+package main
+
+// Timed locate: light a disk's ident LED (or its fault LED when the slot has
+// no ident LED) for a while and switch it off again. Every timer is kept in a
+// state file under -state-dir so that a later run, or `led cleanup` at boot,
+// switches off LEDs whose timer ran out while nothing was waiting on them.
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const locateStateFile = "locate.json"
+
+var stateDir = "/var/lib/localdisk"
+
+type locateTimer struct {
+	Device  string    `json:"device"`
+	Serial  string    `json:"serial,omitempty"`
+	Wwid    string    `json:"wwid,omitempty"`
+	Led     string    `json:"led"`
+	Expires time.Time `json:"expires"`
+	Pid     int       `json:"pid"`
+}
+
+// addStateDirFlag : register the -state-dir flag on a flag set
+func addStateDirFlag(fs *flag.FlagSet) {
+	fs.StringVar(&stateDir, "state-dir", "/var/lib/localdisk", "directory holding the LED state files")
+}
+
+// loadState : read a JSON state file from -state-dir, leaving v untouched when there is none
+func loadState(name string, v interface{}) error {
+	path := filepath.Join(stateDir, name)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(content, v); err != nil {
+		return errors.New("Unable to read " + path + ": " + err.Error())
+	}
+	return nil
+}
+
+// saveState : replace a JSON state file in -state-dir, so a crash never leaves it half written
+func saveState(name string, v interface{}) error {
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	// a temporary file of its own, so concurrent writers never rename each other's partial writes
+	tmp, err := ioutil.TempFile(stateDir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(stateDir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// updateState : load, update and save a state file under an exclusive lock, so concurrent runs
+// never drop each other's changes; nothing is saved when update fails
+func updateState(name string, v interface{}, update func() error) error {
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(filepath.Join(stateDir, name+".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return errors.New("Unable to lock " + name + ": " + err.Error())
+	}
+
+	if err = loadState(name, v); err != nil {
+		return err
+	}
+	if err = update(); err != nil {
+		return err
+	}
+	return saveState(name, v)
+}
+
+// loadLocateTimers : the timers recorded in the state file
+func loadLocateTimers() ([]locateTimer, error) {
+	var timers []locateTimer
+	if err := loadState(locateStateFile, &timers); err != nil {
+		return nil, err
+	}
+	return timers, nil
+}
+
+// updateLocateTimers : replace the recorded timers with what update makes of them, under the state file's lock
+func updateLocateTimers(update func(timers []locateTimer) []locateTimer) error {
+	var timers []locateTimer
+	return updateState(locateStateFile, &timers, func() error {
+		timers = update(timers)
+		if timers == nil {
+			timers = []locateTimer{}
+		}
+		return nil
+	})
+}
+
+// timerDevice : the current device of a timer; device names can change across a reboot, serials don't
+func timerDevice(timer locateTimer) (string, error) {
+	if timer.Serial == "" {
+		return timer.Device, nil
+	}
+	return resolveDisk(timer.Serial)
+}
+
+// expireLocateTimers : switch off the LEDs of timers that ran out, or of every timer when all is set
+func expireLocateTimers(all bool) (int, error) {
+	failed := 0
+	err := updateLocateTimers(func(timers []locateTimer) []locateTimer {
+		var kept []locateTimer
+		now := time.Now()
+		for _, timer := range timers {
+			if !all && now.Before(timer.Expires) {
+				kept = append(kept, timer)
+				continue
+			}
+			devPath, err := timerDevice(timer)
+			if err != nil {
+				// the disk is gone, and its LED with it
+				fmt.Println("Dropping the locate timer of " + timer.Device + ": " + err.Error())
+				continue
+			}
+			if !printLedChange(changeLed(devPath, timer.Led, "off")) {
+				// keep it so the next run tries again
+				kept = append(kept, timer)
+				failed++
+			}
+		}
+		return kept
+	})
+	return failed, err
+}
+
+// locateLed : the LED to blink, ident unless the slot reports it has none
+func locateLed(devPath string) string {
+	status, err := diskLedStatus(devPath)
+	if err == nil && ledState(status, "ident") == ledUnsupported && ledState(status, "fault") != ledUnsupported {
+		return "fault"
+	}
+	return "ident"
+}
+
+// ownsLocateTimer : whether a timer is still recorded, i.e. no newer locate of the disk replaced it
+func ownsLocateTimer(timer locateTimer) (bool, error) {
+	timers, err := loadLocateTimers()
+	if err != nil {
+		return false, err
+	}
+	for _, t := range timers {
+		if t.Pid == timer.Pid && t.Device == timer.Device && t.Expires.Equal(timer.Expires) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// removeLocateTimer : drop a timer from the state file
+func removeLocateTimer(timer locateTimer) error {
+	return updateLocateTimers(func(timers []locateTimer) []locateTimer {
+		var kept []locateTimer
+		for _, t := range timers {
+			if !(t.Pid == timer.Pid && t.Device == timer.Device && t.Expires.Equal(timer.Expires)) {
+				kept = append(kept, t)
+			}
+		}
+		return kept
+	})
+}
+
+// endLocate : switch the LED off if this process still owns the timer
+func endLocate(timer locateTimer) int {
+	owned, err := ownsLocateTimer(timer)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if !owned {
+		// a newer locate of the same disk took over the LED
+		return 0
+	}
+	if !printLedChange(changeLed(timer.Device, timer.Led, "off")) {
+		// leave the timer for led cleanup to retry
+		return 1
+	}
+	if err = removeLocateTimer(timer); err != nil {
+		fmt.Println("Unable to update the LED timer state: " + err.Error())
+		return 1
+	}
+	return 0
+}
+
+// locateCommand : light the target disks for a while, returning the exit code
+func locateCommand(args []string, duration time.Duration) int {
+	if duration <= 0 {
+		fmt.Println("The locate duration must be positive, e.g. -for 20m")
+		return 1
+	}
+	if !liveHost() {
+		fmt.Println("LEDs can only be changed on the live host")
+		return 1
+	}
+	devPaths, changes, err := ledTargets(args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	if len(devPaths) == 0 && len(changes) == 0 {
+		fmt.Println("No local disks match the selection")
+		return 1
+	}
+
+	expires := time.Now().Add(duration)
+	var timers []locateTimer
+	for _, devPath := range devPaths {
+		timer := locateTimer{Device: devPath, Led: locateLed(devPath), Expires: expires, Pid: os.Getpid()}
+		timer.Serial, _ = backend.serialNum(devPath)
+		timer.Wwid, _ = backend.vpd83(devPath)
+		if status, err := diskLedStatus(devPath); err == nil && timer.Led == "fault" && ledState(status, "fault") == ledOn {
+			// switching it off at expiry would hide a real fault
+			changes = append(changes, ledChange{Device: devPath, Led: "fault", Requested: ledOn, Supported: supportedLedStates(status),
+				Error: "no ident LED and the fault LED is already on"})
+			continue
+		}
+		timers = append(timers, timer)
+	}
+
+	// record the timers before lighting the LEDs so nothing can stay lit without one
+	err = updateLocateTimers(func(recorded []locateTimer) []locateTimer {
+		var kept []locateTimer
+		for _, t := range recorded {
+			replaced := false
+			for _, timer := range timers {
+				if t.Device == timer.Device {
+					replaced = true
+				}
+			}
+			if !replaced {
+				kept = append(kept, t)
+			}
+		}
+		return append(kept, timers...)
+	})
+	if err != nil {
+		fmt.Println("Unable to record the LED timers: " + err.Error())
+		return 1
+	}
+
+	var lit []locateTimer
+	for _, timer := range timers {
+		change := changeLed(timer.Device, timer.Led, "on")
+		changes = append(changes, change)
+		if change.Error == "" {
+			lit = append(lit, timer)
+			continue
+		}
+		if change.After == ledOn || change.After == ledUnknown {
+			// the LED may be lit although something after setting it failed; put it out again,
+			// and leave the timer for led cleanup when that fails too
+			off := changeLed(timer.Device, timer.Led, "off")
+			changes = append(changes, off)
+			if off.Error != "" {
+				continue
+			}
+		}
+		removeLocateTimer(timer)
+	}
+	rc := reportLedChanges(changes)
+	if len(lit) == 0 {
+		return 1
+	}
+	if outputFormat != "json" {
+		fmt.Printf("Locating %d disks until %s, interrupt to switch them off early\n", len(lit), expires.Format("15:04:05"))
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	select {
+	case <-time.After(time.Until(expires)):
+	case <-signals:
+	}
+	for _, timer := range lit {
+		if endLocate(timer) != 0 {
+			rc = 1
+		}
+	}
+	return rc
+}
+
+// resolveDisk : the device path of a disk given as a device path, device name, serial number or WWID
+func resolveDisk(selector string) (string, error) {
+	if strings.HasPrefix(selector, "/dev/") {
+		return selector, nil
+	}
+	disks, err := backend.list()
+	if err != nil {
+		return "", err
+	}
+	for _, devPath := range disks {
+		if devName, _ := extractDev(devPath); devName == selector {
+			return devPath, nil
+		}
+	}
+	wanted := strings.ToLower(strings.TrimPrefix(selector, "naa."))
+	for _, devPath := range disks {
+		if serial, err := backend.serialNum(devPath); err == nil && serial == selector {
+			return devPath, nil
+		}
+		if vpd83, err := backend.vpd83(devPath); err == nil && vpd83 != "" && strings.ToLower(vpd83) == wanted {
+			return devPath, nil
+		}
+	}
+	return "", errors.New("No local disk matches " + selector)
+}
@@ -0,0 +1,108 @@
+package main
+
+// Per-disk locks. led-agent, technicians' commands and provisioning jobs can
+// all change the same disk at once, so every change takes an advisory flock
+// on a file under -lock-dir named after the disk's WWID or serial number
+// (device names move between boots and hot-plugs). The holder writes its PID
+// and command line into the file so a run that has to give up can say who
+// is in the way. The state files in -state-dir that runs share across disks
+// have locks of their own, taken by updateState.
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	lockDir  = "/run/localdisk"
+	lockWait = 10 * time.Second
+)
+
+// how often a waiting run retries a held lock
+const lockPollInterval = 100 * time.Millisecond
+
+var lockNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._:-]`)
+
+type lockHolder struct {
+	Pid     int       `json:"pid"`
+	Command string    `json:"command"`
+	Since   time.Time `json:"since"`
+}
+
+// addLockFlags : register the -lock-dir and -lock-wait flags on a flag set
+func addLockFlags(fs *flag.FlagSet) {
+	fs.StringVar(&lockDir, "lock-dir", "/run/localdisk", "directory holding the per-disk lock files")
+	fs.DurationVar(&lockWait, "lock-wait", 10*time.Second, "how long to wait for a disk another run is changing (0 gives up at once)")
+}
+
+// diskLockName : the lock file name of a disk, from its stable identity when it has one
+func diskLockName(devPath string) string {
+	key := desiredKey(diskIdentity(devPath))
+	if key == "" {
+		devName, _ := extractDev(devPath)
+		key = "dev:" + devName
+	}
+	return lockNameUnsafe.ReplaceAllString(key, "_") + ".lock"
+}
+
+// lockHolderText : who holds a lock, from what it wrote into the lock file
+func lockHolderText(path string) string {
+	content, err := ioutil.ReadFile(path)
+	var holder lockHolder
+	if err != nil || json.Unmarshal(content, &holder) != nil || holder.Pid == 0 {
+		return "another process"
+	}
+	return fmt.Sprintf("pid %d (localdisk %s, since %s)", holder.Pid, holder.Command, holder.Since.Local().Format(time.RFC3339))
+}
+
+// lockDisk : take a disk's lock, waiting up to -lock-wait, and return the function releasing it
+func lockDisk(devPath string) (func(), error) {
+	if err := os.MkdirAll(lockDir, 0755); err != nil {
+		return nil, errors.New("Unable to create the lock directory: " + err.Error())
+	}
+	path := filepath.Join(lockDir, diskLockName(devPath))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.New("Unable to open the lock of " + devPath + ": " + err.Error())
+	}
+
+	deadline := time.Now().Add(lockWait)
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, errors.New("Unable to lock " + devPath + ": " + err.Error())
+		}
+		if !time.Now().Before(deadline) {
+			holder := lockHolderText(path)
+			f.Close()
+			if lockWait > 0 {
+				return nil, fmt.Errorf("%s is being changed by %s, gave up after waiting %s", devPath, holder, lockWait)
+			}
+			return nil, errors.New(devPath + " is being changed by " + holder)
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	holder, _ := json.Marshal(lockHolder{Pid: os.Getpid(), Command: strings.Join(os.Args[1:], " "), Since: time.Now()})
+	// the holder is only informational, a lock without it still works
+	if f.Truncate(0) == nil {
+		f.WriteAt(holder, 0)
+	}
+	return func() {
+		f.Truncate(0)
+		f.Close()
+	}, nil
+}
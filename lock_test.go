@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// lockFixture : point the roots, backend and -lock-dir at a temporary tree holding one SCSI disk
+func lockFixture(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "localdisk-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFixture(t, dir, scsiFixture)
+	oldSys, oldBackend, oldLockDir, oldLockWait := sysfsRoot, backend, lockDir, lockWait
+	sysfsRoot, backend, lockDir = filepath.Join(dir, "sys"), sysfsBackend{}, filepath.Join(dir, "run")
+	return dir, func() {
+		sysfsRoot, backend, lockDir, lockWait = oldSys, oldBackend, oldLockDir, oldLockWait
+		os.RemoveAll(dir)
+	}
+}
+
+// holdLock : take a disk's lock file as another process would, recording it as the holder
+func holdLock(t *testing.T, devPath string) *os.File {
+	if err := os.MkdirAll(lockDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(lockDir, diskLockName(devPath)), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+	holder, _ := json.Marshal(lockHolder{Pid: 4242, Command: "led-agent -interval 1m", Since: time.Now()})
+	f.Write(holder)
+	return f
+}
+
+func TestDiskLockName(t *testing.T) {
+	_, cleanup := lockFixture(t)
+	defer cleanup()
+
+	if name := diskLockName("/dev/sda"); name != "wwid:5000c500a1b2c3d4.lock" {
+		t.Errorf("diskLockName(/dev/sda) = %q", name)
+	}
+	if name := diskLockName("/dev/sdz"); name != "dev:sdz.lock" {
+		t.Errorf("diskLockName(/dev/sdz) = %q", name)
+	}
+}
+
+func TestLockDiskHeld(t *testing.T) {
+	_, cleanup := lockFixture(t)
+	defer cleanup()
+	held := holdLock(t, "/dev/sda")
+	defer held.Close()
+
+	tests := []struct {
+		wait    time.Duration
+		message string
+	}{
+		{0, "/dev/sda is being changed by pid 4242 (localdisk led-agent -interval 1m, since "},
+		{300 * time.Millisecond, "gave up after waiting 300ms"},
+	}
+	for _, test := range tests {
+		lockWait = test.wait
+		start := time.Now()
+		unlock, err := lockDisk("/dev/sda")
+		elapsed := time.Since(start)
+		if err == nil {
+			unlock()
+			t.Fatalf("lockDisk with -lock-wait %s took a held lock", test.wait)
+		}
+		if !strings.Contains(err.Error(), test.message) || !strings.Contains(err.Error(), "pid 4242") {
+			t.Errorf("lockDisk with -lock-wait %s: %q, want it to contain %q", test.wait, err, test.message)
+		}
+		if elapsed < test.wait || elapsed > test.wait+time.Second {
+			t.Errorf("lockDisk with -lock-wait %s gave up after %s", test.wait, elapsed)
+		}
+	}
+}
+
+func TestLockDiskReleased(t *testing.T) {
+	_, cleanup := lockFixture(t)
+	defer cleanup()
+	held := holdLock(t, "/dev/sda")
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		held.Close()
+	}()
+
+	lockWait = 5 * time.Second
+	unlock, err := lockDisk("/dev/sda")
+	if err != nil {
+		t.Fatalf("lockDisk didn't take the released lock: %v", err)
+	}
+	path := filepath.Join(lockDir, diskLockName("/dev/sda"))
+	var holder lockHolder
+	content, _ := ioutil.ReadFile(path)
+	if err = json.Unmarshal(content, &holder); err != nil || holder.Pid != os.Getpid() {
+		t.Errorf("lock holder = %q, want pid %d", content, os.Getpid())
+	}
+	unlock()
+	if content, _ = ioutil.ReadFile(path); len(content) != 0 {
+		t.Errorf("lock holder left after unlock: %q", content)
+	}
+}
+
+func TestUpdateStateSerializes(t *testing.T) {
+	dir, cleanup := lockFixture(t)
+	defer cleanup()
+	oldStateDir := stateDir
+	defer func() { stateDir = oldStateDir }()
+	stateDir = filepath.Join(dir, "state")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counts := make(map[string]int)
+			err := updateState("counts.json", &counts, func() error {
+				counts["updates"]++
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	counts := make(map[string]int)
+	if err := loadState("counts.json", &counts); err != nil || counts["updates"] != 20 {
+		t.Errorf("after 20 concurrent updates counts = %v, %v", counts, err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(stateDir, "*.tmp")); len(tmp) != 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
-// build requires libstoragemgmt-devel and libstoragemgmt installed (for cgo integration)
-// execution requires libstoragemgmt installed on the host
+// libstoragemgmt is loaded at runtime when present (cgo builds only); without
+// it the LSM provided fields are reported as "lsm unavailable"
 //
 // Build with the following command
-// CGO_LDFLAGS=/usr/lib64/libstoragemgmt.so go build -o localdisk
+// go build -o localdisk
+//
+// or without cgo, using only the sysfs backend
+// CGO_ENABLED=0 go build -o localdisk
 //
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -15,13 +19,17 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	lsm "github.com/libstorage/libstoragemgmt-golang"
-	localdisk "github.com/libstorage/libstoragemgmt-golang/localdisk"
 )
 
 var version = "dev"
 
+var outputFormat = "text"
+var whereFilter = ""
+var stallPeriod time.Duration
+
 var healthText = map[lsm.DiskHealthStatus]string{
 	lsm.DiskHealthStatusUnknown: "Unknown",
 	lsm.DiskHealthStatusFail:    "Fail",
@@ -56,12 +64,88 @@ type disk struct {
 	rpm          int32
 	ledIdent     string
 	ledFail      string
+	ledStatus    lsm.DiskLedStatusBitField
+	ledPattern   string
 	health       string
 	model        string
 	revision     string
 	vendor       string
 	wwid         string
 	sectorFormat string
+	smart        []smartAttribute
+	nvmeHealth   *nvmeHealthLog
+	scsiLogs     *scsiLogPages
+	temperature  *diskTemperature
+	vpd          *vpdPages
+	ataIdentity  *ataIdentity
+	nvmeIdentity *nvmeIdentity
+	stall        *stallReport
+	lsmMissing   bool
+}
+
+// MarshalJSON : expose the disk fields to encoding/json
+func (d disk) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		DevPath         string           `json:"dev_path"`
+		DevType         string           `json:"dev_type"`
+		SerialNumber    string           `json:"serial_number"`
+		Vpd83           string           `json:"vpd83"`
+		SizeBytes       int64            `json:"size_bytes"`
+		SizeSectors     int64            `json:"size_sectors"`
+		SectorFormat    string           `json:"sector_format"`
+		Transport       string           `json:"transport"`
+		LinkSpeed       uint32           `json:"link_speed"`
+		Rpm             int32            `json:"rpm"`
+		LedIdent        string           `json:"led_ident"`
+		LedFail         string           `json:"led_fail"`
+		LedStatus       uint32           `json:"led_status"`
+		LedStatusFlags  []string         `json:"led_status_flags"`
+		LedPattern      string           `json:"led_pattern,omitempty"`
+		Health          string           `json:"health"`
+		Vendor          string           `json:"vendor"`
+		Model           string           `json:"model"`
+		Revision        string           `json:"revision"`
+		Wwid            string           `json:"wwid"`
+		Temperature     *diskTemperature `json:"temperature,omitempty"`
+		SmartAttributes []smartAttribute `json:"smart_attributes,omitempty"`
+		NvmeHealth      *nvmeHealthLog   `json:"nvme_health,omitempty"`
+		ScsiLogPages    *scsiLogPages    `json:"scsi_log_pages,omitempty"`
+		Vpd             *vpdPages        `json:"vpd,omitempty"`
+		AtaIdentify     *ataIdentity     `json:"ata_identify,omitempty"`
+		NvmeIdentify    *nvmeIdentity    `json:"nvme_identify,omitempty"`
+		IoStall         *stallReport     `json:"io_stall,omitempty"`
+		LsmUnavailable  bool             `json:"lsm_unavailable,omitempty"`
+	}{
+		DevPath:         d.devPath,
+		DevType:         d.devType,
+		SerialNumber:    d.serialNumber,
+		Vpd83:           d.vpd83,
+		SizeBytes:       d.sizeBytes,
+		SizeSectors:     d.sizeSectors,
+		SectorFormat:    d.sectorFormat,
+		Transport:       d.transport,
+		LinkSpeed:       d.linkSpeed,
+		Rpm:             d.rpm,
+		LedIdent:        d.ledIdent,
+		LedFail:         d.ledFail,
+		LedStatus:       uint32(d.ledStatus),
+		LedStatusFlags:  ledStatusFlags(d.ledStatus),
+		LedPattern:      d.ledPattern,
+		Health:          d.health,
+		Vendor:          d.vendor,
+		Model:           d.model,
+		Revision:        d.revision,
+		Wwid:            d.wwid,
+		Temperature:     d.temperature,
+		SmartAttributes: d.smart,
+		NvmeHealth:      d.nvmeHealth,
+		ScsiLogPages:    d.scsiLogs,
+		Vpd:             d.vpd,
+		AtaIdentify:     d.ataIdentity,
+		NvmeIdentify:    d.nvmeIdentity,
+		IoStall:         d.stall,
+		LsmUnavailable:  d.lsmMissing,
+	})
 }
 
 // bytesToHuman : convert a bytes value to a human readable format
@@ -79,26 +163,39 @@ func bytesToHuman(b int64) string {
 	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
 }
 
-// ConvertLedStatus : return a text value for an led state bit
-func convertLedStatus(bitField lsm.DiskLedStatusBitField, offset uint) (string, error) {
-	var ledText string
-	ledState := bitField >> offset
-	switch ledState {
-	case 1:
-		ledText = "ON"
-	case 2:
-		ledText = "OFF"
-	case 4:
-		ledText = "UNKNOWN"
-	default:
-		ledText = "UNKNOWN"
+// worseHealth : return the more severe of two health values, preferring any known value over Unknown
+func worseHealth(current string, other string) string {
+	rank := map[string]int{"": 0, "Unknown": 0, "Good": 1, "Warn": 2, "Fail": 3}
+	if rank[other] > rank[current] {
+		return other
+	}
+	return current
+}
+
+// passThroughHealth : derive health from SMART, SCSI informational exceptions and the NVMe health log
+func passThroughHealth(disk *disk) string {
+	health := ""
+	for _, attr := range disk.smart {
+		health = "Good"
+		if attr.Failing {
+			return "Fail"
+		}
+	}
+	if disk.scsiLogs != nil && disk.scsiLogs.InfoExceptions != nil {
+		health = "Good"
+		if disk.scsiLogs.InfoExceptions.FailurePredicted {
+			return "Fail"
+		}
+	}
+	if disk.nvmeHealth != nil {
+		health = worseHealth(health, disk.nvmeHealth.status())
 	}
-	return ledText, nil
+	return health
 }
 
-// readFile: read contents of a given filepath
+// readFile: read contents of a given filepath, relative to the configured roots
 func readFile(fname string) (string, error) {
-	dat, err := ioutil.ReadFile(fname)
+	dat, err := ioutil.ReadFile(hostPath(fname))
 	if err != nil {
 		return "", err
 	}
@@ -131,28 +228,41 @@ func getDeviceAttr(devicePath string, attr string) (string, error) {
 	return content, nil
 }
 
-// getDiskInfo : use lsm to get disk metadata
+// getDiskInfo : use the selected backend to get disk metadata
 func getDiskInfo(devPath string, disk *disk) error {
 
 	var health lsm.DiskHealthStatus
 	var linkType lsm.DiskLinkType
-	var ledStatus lsm.DiskLedStatusBitField
 
-	if _, err := os.Stat(devPath); os.IsNotExist(err) {
-		return errors.New("Device path not found")
+	// captured trees have no device nodes, so the sysfs entry is enough
+	devName, _ := extractDev(devPath)
+	if _, err := os.Stat(hostPath(devPath)); os.IsNotExist(err) {
+		if _, err := os.Stat(hostPath("/sys/class/block/" + devName)); os.IsNotExist(err) {
+			return errors.New("Device path not found")
+		}
 	}
 
 	disk.devPath = devPath
-	disk.serialNumber, _ = localdisk.SerialNumGet(devPath)
+	disk.lsmMissing = backend.available() != nil
+	disk.serialNumber, _ = backend.serialNum(devPath)
 
 	// We supplement the data available from LSM with direct queries into sysfs
-	devName, _ := extractDev(devPath)
 	sizeStr, _ := getDeviceAttr(devPath, "/block/"+devName+"/size")
 	disk.sizeSectors, _ = strconv.ParseInt(sizeStr, 10, 64)
 	disk.model, _ = getDeviceAttr(devPath, "model")
 	disk.vendor, _ = getDeviceAttr(devPath, "vendor")
 	disk.wwid, _ = getDeviceAttr(devPath, "wwid")
 	disk.revision, _ = getDeviceAttr(devPath, "rev")
+	if !strings.HasPrefix(devName, "nvme") {
+		disk.vpd, _ = vpdPagesGet(devPath)
+	}
+	if disk.vendor == "ATA" {
+		// libata (and SAS HBAs passing SATA disks through) report the vendor as ATA
+		disk.ataIdentity, _ = ataIdentifyGet(devPath)
+		disk.smart, _ = ataSmartGet(devPath)
+	} else if !strings.HasPrefix(devName, "nvme") {
+		disk.scsiLogs, _ = scsiLogPagesGet(devPath)
+	}
 	physicalSector, _ := getDeviceAttr(devPath, "/block/"+devName+"/queue/physical_block_size")
 	logicalSector, _ := getDeviceAttr(devPath, "/block/"+devName+"/queue/logical_block_size")
 	if logicalSector == physicalSector {
@@ -171,9 +281,26 @@ func getDiskInfo(devPath string, disk *disk) error {
 		disk.sizeBytes = disk.sizeSectors * 512
 	}
 
-	health, _ = localdisk.HealthStatusGet(devPath)
+	health, _ = backend.healthStatus(devPath)
 	disk.health = healthText[health]
-	disk.rpm, _ = localdisk.RpmGet(devPath)
+	if disk.lsmMissing {
+		disk.health = lsmUnavailableText
+	}
+	if strings.HasPrefix(devName, "nvme") {
+		disk.nvmeHealth, _ = nvmeHealthGet(devPath)
+		disk.nvmeIdentity, _ = nvmeIdentityGet(devPath)
+	}
+	disk.health = worseHealth(disk.health, passThroughHealth(disk))
+	disk.temperature = hwmonTempGet(devPath)
+	if disk.temperature == nil {
+		disk.temperature = passThroughTemp(disk)
+	}
+	disk.rpm, _ = backend.rpm(devPath)
+
+	if disk.lsmMissing {
+		// the media type is still known from sysfs
+		disk.rpm, _ = sysfsBackend{}.rpm(devPath)
+	}
 
 	switch disk.rpm {
 	case 0:
@@ -182,46 +309,196 @@ func getDiskInfo(devPath string, disk *disk) error {
 		disk.devType = "HDD"
 	}
 
-	disk.vpd83, _ = localdisk.Vpd83Get(devPath)
-	disk.linkSpeed, _ = localdisk.LinkSpeedGet(devPath)
-	linkType, _ = localdisk.LinkTypeGet(devPath)
+	disk.vpd83, _ = backend.vpd83(devPath)
+	disk.linkSpeed, _ = backend.linkSpeed(devPath)
+	linkType, _ = backend.linkType(devPath)
 	disk.transport = linkText[linkType]
-	ledStatus, _ = localdisk.LedStatusGet(devPath)
+	disk.ledStatus = lsm.DiskLedStatusUnknown
+	if ledStatus, err := diskLedStatus(devPath); err == nil {
+		disk.ledStatus = ledStatus
+	}
+	disk.ledIdent = ledState(disk.ledStatus, "ident")
+	disk.ledFail = ledState(disk.ledStatus, "fault")
+	if _, err := enclosureComponent(devPath); err == nil {
+		disk.ledPattern, _ = sesPattern(devPath)
+	}
 
-	// Testing:
-	// ledStatus = lsm.DiskLedStatusBitField(0x0000000000000004)
-	if ledStatus == 1 {
-		disk.ledIdent = "Unavailable"
-		disk.ledFail = "Unavailable"
-	} else {
-		disk.ledIdent, _ = convertLedStatus(ledStatus, 1)
-		disk.ledFail, _ = convertLedStatus(ledStatus, 4)
+	if disk.lsmMissing {
+		// only report what the pass-through and sysfs collectors found
+		disk.serialNumber = lsmUnavailableText
+		disk.vpd83 = lsmUnavailableText
+		disk.transport = lsmUnavailableText
+		if disk.ledStatus&lsm.DiskLedStatusUnknown != 0 {
+			// NPEM or the PCIe slot can still have reported the LEDs
+			disk.ledIdent = lsmUnavailableText
+			disk.ledFail = lsmUnavailableText
+		}
+		disk.rpm = diskRpmUnknown
+		disk.linkSpeed = 0
 	}
 
 	return nil
 }
 
-// setFailLed : set/unset the fail led
-func setFailLed(devPath string, state string) error {
+// rpmText : the rpm for display
+func (d *disk) rpmText() string {
+	if d.lsmMissing {
+		return lsmUnavailableText
+	}
+	return strconv.Itoa(int(d.rpm))
+}
 
-	switch state {
-	case "on":
-		return localdisk.FaultLedOn(devPath)
-	case "off":
-		return localdisk.FaultLedOff(devPath)
-	default:
-		return nil
+// linkSpeedText : the link speed for display
+func (d *disk) linkSpeedText() string {
+	if d.lsmMissing {
+		return lsmUnavailableText
+	}
+	return strconv.Itoa(int(d.linkSpeed))
+}
+
+// setFailLed : set/unset the fail led, returning how it was set
+func setFailLed(devPath string, state string) (string, error) {
+	return setDiskLed(devPath, "fault", state)
+}
+
+// setIdentLed : set/unset the ident (locate) led, returning how it was set
+func setIdentLed(devPath string, state string) (string, error) {
+	return setDiskLed(devPath, "ident", state)
+}
+
+// setDiskLed : set a LED through the RAID plugin, the backend or, for NVMe drives the
+// backend can't reach, NPEM or the hotplug slot, and record it as the desired state
+func setDiskLed(devPath string, led string, state string) (string, error) {
+	if !liveHost() {
+		return "", errors.New("LEDs can only be changed on the live host")
+	}
+	if state != "on" && state != "off" {
+		return "", errors.New("Unknown LED state " + state)
+	}
+
+	var err error
+	var mechanism string
+	handled := false
+	if uri := raidPluginURI(devPath); uri != "" {
+		mechanism = uri
+		if led == "ident" {
+			handled, err = raidIdentLed(devPath, uri, state)
+		} else {
+			handled, err = raidFaultLed(devPath, uri)
+		}
+	}
+	if !handled {
+		mechanism = backend.name()
+		err = raidFallback(err, func() error {
+			switch {
+			case led == "ident" && state == "on":
+				return backend.identLedOn(devPath)
+			case led == "ident":
+				return backend.identLedOff(devPath)
+			case state == "on":
+				return backend.faultLedOn(devPath)
+			default:
+				return backend.faultLedOff(devPath)
+			}
+		})
+		if err != nil && isNvme(devPath) {
+			nvmeMechanism, nvmeErr := nvmeSetLed(devPath, led, state)
+			if nvmeErr != nil {
+				err = errors.New(err.Error() + "; " + nvmeErr.Error())
+			} else {
+				mechanism, err = nvmeMechanism, nil
+			}
+		}
+	}
+	if err != nil {
+		return mechanism, err
+	}
+	if err = recordDesiredLed(devPath, led, state); err != nil {
+		return mechanism, errors.New("the LED was set but its desired state wasn't recorded: " + err.Error())
+	}
+	return mechanism, nil
+}
+
+// redactDisks : with -redact, replace identifying values with their pseudonyms
+func redactDisks(disks []disk) {
+	if activeRedactor == nil {
+		return
+	}
+	// register everything first so values shared between disks are masked everywhere
+	for i := range disks {
+		activeRedactor.registerDisk(&disks[i])
+	}
+	for i := range disks {
+		activeRedactor.redactDisk(&disks[i])
+	}
+	if err := saveRedactor(); err != nil {
+		fmt.Println("Unable to save the redaction file " + redactFile + ": " + err.Error())
+		os.Exit(1)
+	}
+}
+
+// printJSON : write a value to stdout as indented JSON
+func printJSON(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Println("Unable to encode output: " + err.Error())
+		os.Exit(1)
 	}
+	fmt.Println(string(out))
 }
 
-// listDisks : show all the local disks on the system
-func listDisks() {
+// listDisks : show all the local disks on the system, returning the exit code
+func listDisks() int {
 	var disks []string
-	var disk disk
+	var found []disk
+	var rc int
+
+	filters, err := parseFilters(whereFilter)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
 
-	disks, _ = localdisk.List()
+	disks, _ = backend.list()
 	// TODO check if list has entries
-	fmt.Println(fmt.Sprintf("%-16s %6s %-15s %15s %6s %10s %5s %9s %11s %11s %7s %16s %16s %8s %20s",
+	var all []disk
+	for _, devPath := range disks {
+		var disk disk
+		_ = getDiskInfo(devPath, &disk)
+		all = append(all, disk)
+	}
+
+	// stall detection runs first so -where can select on its results
+	var stalls map[string]*stallReport
+	if stallPeriod > 0 {
+		var devNames []string
+		for _, disk := range all {
+			devName, _ := extractDev(disk.devPath)
+			devNames = append(devNames, devName)
+		}
+		stalls = detectStalls(devNames, stallPeriod, time.Second)
+		applyStallReports(all, stalls)
+	}
+	for _, disk := range all {
+		if matchFilters(&disk, filters) {
+			found = append(found, disk)
+		}
+	}
+	if stalls != nil {
+		// only the listed disks decide the exit code
+		rc = applyStallReports(found, stalls)
+	}
+	redactDisks(found)
+
+	if outputFormat == "json" {
+		if found == nil {
+			found = []disk{}
+		}
+		printJSON(found)
+		return rc
+	}
+
+	fmt.Println(fmt.Sprintf("%-16s %6s %-15s %15s %6s %10s %5s %9s %11s %11s %7s %5s %16s %16s %8s %20s",
 		"Device Path",
 		"Type",
 		"Serial Number",
@@ -233,31 +510,41 @@ func listDisks() {
 		"IDENT",
 		"FAIL",
 		"Health",
+		"Temp",
 		"Vendor",
 		"Model",
 		"Revision",
 		"wwid"))
 
-	for _, devPath := range disks {
-		_ = getDiskInfo(devPath, &disk)
-
-		fmt.Println(fmt.Sprintf("%-16s %6s %-15s %15s %6s %10s %5d %9d %11s %11s %7s %16s %16s %8s %20s",
+	for _, disk := range found {
+		temp := "-"
+		if disk.temperature != nil {
+			temp = fmt.Sprintf("%.0fC", disk.temperature.Current)
+		}
+		fmt.Println(fmt.Sprintf("%-16s %6s %-15s %15s %6s %10s %5s %9s %11s %11s %7s %5s %16s %16s %8s %20s",
 			disk.devPath,
 			disk.devType,
 			disk.serialNumber,
 			bytesToHuman(disk.sizeBytes),
 			disk.sectorFormat,
 			disk.transport,
-			disk.rpm,
-			disk.linkSpeed,
+			disk.rpmText(),
+			disk.linkSpeedText(),
 			disk.ledIdent,
 			disk.ledFail,
 			disk.health,
+			temp,
 			disk.vendor,
 			disk.model,
 			disk.revision,
 			disk.wwid))
 	}
+	for _, disk := range found {
+		if disk.stall != nil && disk.stall.Problem {
+			fmt.Printf("%s: %s\n", disk.devPath, disk.stall.Reason)
+		}
+	}
+	return rc
 }
 
 // showDisk : Show details for a specific disk
@@ -270,6 +557,19 @@ func showDisk(devPath string) {
 		fmt.Println("Unable to list device " + devPath)
 		os.Exit(1)
 	}
+	if activeRedactor != nil {
+		activeRedactor.registerDisk(&disk)
+		activeRedactor.redactDisk(&disk)
+		if err = saveRedactor(); err != nil {
+			fmt.Println("Unable to save the redaction file " + redactFile + ": " + err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if outputFormat == "json" {
+		printJSON(disk)
+		return
+	}
 
 	fmt.Printf("Device Path    : %s\n", (disk.devPath))
 	fmt.Printf("Type           : %s\n", (disk.devType))
@@ -277,48 +577,307 @@ func showDisk(devPath string) {
 	fmt.Printf("Size           : %s\n", (bytesToHuman(disk.sizeBytes)))
 	fmt.Printf("Sector Format  : %s\n", (disk.sectorFormat))
 	fmt.Printf("Transport      : %s\n", (disk.transport))
-	fmt.Printf("RPM            : %d\n", (disk.rpm))
-	fmt.Printf("Bus Speed      : %d\n", (disk.linkSpeed))
+	fmt.Printf("RPM            : %s\n", (disk.rpmText()))
+	fmt.Printf("Bus Speed      : %s\n", (disk.linkSpeedText()))
 	fmt.Printf("IDENT LED      : %s\n", (disk.ledIdent))
 	fmt.Printf("FAIL LED       : %s\n", (disk.ledFail))
+	if disk.ledPattern != "" {
+		fmt.Printf("LED Pattern    : %s\n", (disk.ledPattern))
+	}
 	fmt.Printf("Health         : %s\n", (disk.health))
+	if t := disk.temperature; t != nil {
+		fmt.Printf("Temperature    : %.1f C (%s)\n", t.Current, t.Source)
+		if t.Min != nil {
+			fmt.Printf("Temp Min       : %.1f C\n", *t.Min)
+		}
+		if t.Max != nil {
+			fmt.Printf("Temp Max       : %.1f C\n", *t.Max)
+		}
+		if t.Critical != nil {
+			fmt.Printf("Temp Critical  : %.1f C\n", *t.Critical)
+		}
+	}
 	fmt.Printf("Vendor         : %s\n", (disk.vendor))
 	fmt.Printf("Model          : %s\n", (disk.model))
 	fmt.Printf("Revision       : %s\n", (disk.revision))
 	fmt.Printf("wwid           : %s\n", (disk.wwid))
+
+	if len(disk.smart) > 0 {
+		fmt.Println("SMART Attributes:")
+		fmt.Printf("  %3s %-24s %5s %5s %6s %6s %s\n", "ID", "Name", "Value", "Worst", "Thresh", "Failed", "Raw")
+		for _, attr := range disk.smart {
+			failed := "-"
+			if attr.Failing {
+				failed = "NOW"
+			}
+			fmt.Printf("  %3d %-24s %5d %5d %6d %6s %d\n",
+				attr.ID, attr.Name, attr.Value, attr.Worst, attr.Threshold, failed, attr.displayRaw())
+		}
+	}
+
+	if h := disk.nvmeHealth; h != nil {
+		fmt.Println("NVMe Health Information:")
+		fmt.Printf("  Critical Warning   : 0x%02x\n", h.CriticalWarning)
+		for _, warning := range h.CriticalWarnings {
+			fmt.Printf("                       %s\n", warning)
+		}
+		fmt.Printf("  Temperature        : %d C\n", h.TemperatureCelsius)
+		fmt.Printf("  Available Spare    : %d%% (threshold %d%%)\n", h.AvailableSpare, h.AvailableSpareThresh)
+		fmt.Printf("  Percentage Used    : %d%%\n", h.PercentageUsed)
+		fmt.Printf("  Data Units Read    : %d [%s]\n", h.DataUnitsRead, bytesToHuman(int64(h.DataUnitsRead*nvmeDataUnitBytes)))
+		fmt.Printf("  Data Units Written : %d [%s]\n", h.DataUnitsWritten, bytesToHuman(int64(h.DataUnitsWritten*nvmeDataUnitBytes)))
+		fmt.Printf("  Power On Hours     : %d\n", h.PowerOnHours)
+		fmt.Printf("  Unsafe Shutdowns   : %d\n", h.UnsafeShutdowns)
+		fmt.Printf("  Media Errors       : %d\n", h.MediaErrors)
+		fmt.Printf("  Error Log Entries  : %d\n", h.ErrorLogEntries)
+	}
+
+	if l := disk.scsiLogs; l != nil {
+		showScsiLogPages(l)
+	}
+
+	if v := disk.vpd; v != nil {
+		showVpdPages(v)
+	}
+
+	if id := disk.ataIdentity; id != nil {
+		showAtaIdentity(id)
+	}
+
+	if id := disk.nvmeIdentity; id != nil {
+		showNvmeIdentity(id)
+	}
+}
+
+// showNvmeIdentity : print the decoded NVMe Identify Controller/Namespace data
+func showNvmeIdentity(id *nvmeIdentity) {
+	if c := id.Controller; c != nil {
+		fmt.Println("NVMe Controller:")
+		fmt.Printf("  Model              : %s\n", c.Model)
+		fmt.Printf("  Serial             : %s\n", c.Serial)
+		fmt.Printf("  Firmware           : %s\n", c.Firmware)
+		fmt.Printf("  Firmware Slots     : %d (active %d, slot 1 read only %t)\n", c.FirmwareSlotCount, c.ActiveFirmwareSlot, c.FirmwareSlot1RO)
+		for _, slot := range c.FirmwareSlots {
+			active := ""
+			if slot.Active {
+				active = " (active)"
+			}
+			fmt.Printf("    Slot %d           : %s%s\n", slot.Slot, slot.Revision, active)
+		}
+		fmt.Printf("  Optional Admin Cmds: %s\n", strings.Join(c.OptionalAdminCmds, ", "))
+		if len(c.SanitizeOperations) > 0 {
+			fmt.Printf("  Sanitize           : %s\n", strings.Join(c.SanitizeOperations, ", "))
+		}
+		fmt.Printf("  Volatile Cache     : %t\n", c.VolatileWriteCache)
+		fmt.Printf("  Namespaces         : %d\n", c.NamespaceCount)
+		fmt.Printf("  Total Capacity     : %s (unallocated %s)\n", bytesToHuman(int64(c.TotalCapacity)), bytesToHuman(int64(c.UnallocatedCapacity)))
+	}
+	if n := id.Namespace; n != nil {
+		fmt.Println("NVMe Namespace:")
+		fmt.Printf("  Namespace ID       : %d\n", n.ID)
+		fmt.Printf("  Size               : %s (%d blocks)\n", bytesToHuman(int64(n.SizeBytes)), n.SizeBlocks)
+		fmt.Printf("  Capacity           : %s (%d blocks)\n", bytesToHuman(int64(n.CapacityBytes)), n.CapacityBlocks)
+		fmt.Printf("  Utilization        : %s (%d blocks)\n", bytesToHuman(int64(n.UtilizationBytes)), n.UsedBlocks)
+		fmt.Printf("  Protection Type    : %d\n", n.ProtectionType)
+		fmt.Printf("  %-6s %9s %9s %6s %-9s\n", "Format", "Data Size", "Metadata", "PI", "Perf")
+		for _, f := range n.LBAFormats {
+			inUse := ""
+			if f.InUse {
+				inUse = "(in use)"
+			}
+			fmt.Printf("  %-6d %9d %9d %6d %-9s %s\n", f.Index, f.DataSize, f.MetadataSize, f.ProtectionSize, f.RelativePerformance, inUse)
+		}
+	}
+}
+
+// supportText : describe the supported/enabled state of an ATA feature
+func supportText(f ataFeature) string {
+	switch {
+	case !f.Supported:
+		return "Unsupported"
+	case f.Enabled:
+		return "Enabled"
+	default:
+		return "Disabled"
+	}
+}
+
+// showAtaIdentity : print the decoded ATA IDENTIFY DEVICE data
+func showAtaIdentity(id *ataIdentity) {
+	fmt.Println("ATA Identify:")
+	fmt.Printf("  Model              : %s\n", id.Model)
+	fmt.Printf("  Serial             : %s\n", id.Serial)
+	fmt.Printf("  Firmware           : %s\n", id.Firmware)
+	fmt.Printf("  WWN                : %s\n", id.WWN)
+	fmt.Printf("  Sector Size        : %d logical, %d physical\n", id.LogicalSector, id.PhysicalSector)
+	fmt.Printf("  Form Factor        : %s\n", id.FormFactor)
+	fmt.Printf("  Rotation Rate      : %s\n", id.RotationRate)
+	fmt.Printf("  TRIM               : %t (deterministic %t, zero after TRIM %t)\n",
+		id.Trim.Supported, id.Trim.Deterministic, id.Trim.ZeroAfterTrim)
+	fmt.Printf("  NCQ                : %s (depth %d)\n", supportText(id.NCQ), id.NCQDepth)
+	fmt.Printf("  SMART              : %s\n", supportText(id.Smart))
+	fmt.Printf("  Write Cache        : %s\n", supportText(id.WriteCache))
+	fmt.Printf("  Read Look-Ahead    : %s\n", supportText(id.ReadLookAhead))
+	fmt.Printf("  Security           : %s (locked %t, frozen %t)\n",
+		supportText(ataFeature{Supported: id.Security.Supported, Enabled: id.Security.Enabled}),
+		id.Security.Locked, id.Security.Frozen)
+}
+
+// showVpdPages : print the decoded INQUIRY VPD pages
+func showVpdPages(v *vpdPages) {
+	fmt.Println("VPD:")
+	fmt.Printf("  Supported Pages    : %s\n", strings.Join(v.Supported, " "))
+	if v.UnitSerial != "" {
+		fmt.Printf("  Unit Serial        : %s\n", v.UnitSerial)
+	}
+	for _, d := range v.Designators {
+		fmt.Printf("  Designator         : %-22s %-14s %s\n", d.Type, d.Association, d.Value)
+	}
+	if b := v.BlockLimits; b != nil {
+		fmt.Printf("  Max Transfer       : %d blocks\n", b.MaxTransferLength)
+		fmt.Printf("  Optimal Transfer   : %d blocks (granularity %d)\n", b.OptimalTransferLength, b.OptimalTransferGranularity)
+		fmt.Printf("  Max Unmap          : %d blocks, %d descriptors\n", b.MaxUnmapLBACount, b.MaxUnmapDescriptorCount)
+		fmt.Printf("  Unmap Granularity  : %d blocks\n", b.OptimalUnmapGranularity)
+		fmt.Printf("  Max Write Same     : %d blocks\n", b.MaxWriteSameLength)
+	}
+	if c := v.BlockCharacteristics; c != nil {
+		fmt.Printf("  Rotation           : %s\n", c.Rotation)
+		fmt.Printf("  Form Factor        : %s\n", c.FormFactor)
+		fmt.Printf("  Zoned              : %s\n", c.Zoned)
+	}
+	if p := v.Provisioning; p != nil {
+		fmt.Printf("  Provisioning       : %s\n", p.ProvisioningType)
+		fmt.Printf("  Unmap              : %t (write same 16 %t, write same 10 %t)\n", p.Unmap, p.WriteSame16Unmap, p.WriteSame10Unmap)
+		fmt.Printf("  LBPRZ              : %d\n", p.ReadZeroes)
+	}
+}
+
+// showScsiLogPages : print the decoded SCSI log page counters
+func showScsiLogPages(l *scsiLogPages) {
+	fmt.Println("SCSI Log Pages:")
+	if l.Temperature != nil {
+		fmt.Printf("  Temperature        : %d C (reference %d C)\n", l.Temperature.Current, l.Temperature.Reference)
+	}
+	if s := l.StartStop; s != nil {
+		fmt.Printf("  Manufactured       : %s\n", s.ManufactureDate)
+		fmt.Printf("  Start-Stop Cycles  : %d (specified %d)\n", s.AccumulatedStartStops, s.SpecifiedStartStops)
+		fmt.Printf("  Load-Unload Cycles : %d (specified %d)\n", s.AccumulatedLoadUnloads, s.SpecifiedLoadUnloads)
+	}
+	if l.NonMediumErrors != nil {
+		fmt.Printf("  Non-Medium Errors  : %d\n", *l.NonMediumErrors)
+	}
+	if l.PercentUsed != nil {
+		fmt.Printf("  Percentage Used    : %d%%\n", *l.PercentUsed)
+	}
+	if ie := l.InfoExceptions; ie != nil {
+		fmt.Printf("  Info Exceptions    : asc 0x%02x ascq 0x%02x (failure predicted: %t)\n", ie.Asc, ie.Ascq, ie.FailurePredicted)
+	}
+	if l.ReadErrors != nil || l.WriteErrors != nil || l.VerifyErrors != nil {
+		fmt.Printf("  %-8s %12s %12s %12s %12s %16s %12s\n", "Errors", "ECC fast", "ECC delayed", "Rereads", "Corrected", "Bytes", "Uncorrected")
+		for _, row := range []struct {
+			name     string
+			counters *scsiErrorCounters
+		}{{"read", l.ReadErrors}, {"write", l.WriteErrors}, {"verify", l.VerifyErrors}} {
+			if c := row.counters; c != nil {
+				fmt.Printf("  %-8s %12d %12d %12d %12d %16s %12d\n", row.name, c.CorrectedNoDelay, c.CorrectedDelayed,
+					c.Retries, c.TotalCorrected, bytesToHuman(int64(c.BytesProcessed)), c.Uncorrected)
+			}
+		}
+	}
+	if len(l.SelfTests) > 0 {
+		fmt.Printf("  %-3s %-20s %-26s %8s %20s\n", "#", "Self-Test", "Result", "Hours", "First Failure LBA")
+		for _, t := range l.SelfTests {
+			fmt.Printf("  %-3d %-20s %-26s %8d %20d\n", t.Number, t.Type, t.Result, t.PowerOnHours, t.FirstFailureLBA)
+		}
+	}
 }
 
 func main() {
 	var err error
 
+	// subcommands parse their own flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "stats":
+			os.Exit(statsCommand(os.Args[2:]))
+		case "stall":
+			os.Exit(stallCommand(os.Args[2:]))
+		case "capture":
+			os.Exit(captureCommand(os.Args[2:]))
+		case "led":
+			os.Exit(ledCommand(os.Args[2:]))
+		case "led-agent":
+			os.Exit(ledAgentCommand(os.Args[2:]))
+		case "history":
+			os.Exit(historyCommand(os.Args[2:]))
+		}
+	}
+
 	listDisksPtr := flag.Bool("list", false, "list all local disks")
 	getDiskPtr := flag.String("show", "", "show a specific disk matching given /dev name")
 	setFailOnPtr := flag.String("fail-led-on", "", "activate fail LED on a given device")
 	setFailOffPtr := flag.String("fail-led-off", "", "de-activate fail LED on a given device")
+	setIdentOnPtr := flag.String("ident-led-on", "", "activate ident (locate) LED on a given device")
+	setIdentOffPtr := flag.String("ident-led-off", "", "de-activate ident (locate) LED on a given device")
 	versionPtr := flag.Bool("version", true, "print version")
+	flag.StringVar(&outputFormat, "output", "text", "output format for -list and -show (text or json)")
+	flag.DurationVar(&stallPeriod, "stall-period", 0, "with -list, mark disks with outstanding I/O and no completions for this long as failed")
+	addBackendFlag(flag.CommandLine)
+	addRootFlags(flag.CommandLine)
+	addRedactFlags(flag.CommandLine)
+	addStateDirFlag(flag.CommandLine)
+	addAuditLogFlag(flag.CommandLine)
+	addLockFlags(flag.CommandLine)
+	flag.StringVar(&whereFilter, "where", "", "only list disks matching comma separated conditions, e.g. 'temp>50,health!=Good'")
 	flag.Parse()
 
-	if *versionPtr {
+	if outputFormat != "text" && outputFormat != "json" {
+		fmt.Println("Unsupported output format " + outputFormat)
+		os.Exit(1)
+	}
+
+	if err = applyRootFlags(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err = initBackend(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err = initRedactor(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// keep json output machine readable
+	if *versionPtr && outputFormat != "json" {
 		fmt.Printf("version: %q\n", version)
 	}
 
 	if *listDisksPtr {
-		listDisks()
-		os.Exit(0)
+		os.Exit(listDisks())
 	}
 	if *getDiskPtr != "" {
 		showDisk(*getDiskPtr)
 		os.Exit(0)
 	}
-	if *setFailOnPtr != "" || *setFailOffPtr != "" {
-		if *setFailOnPtr != "" {
-			err = setFailLed(*setFailOnPtr, "on")
-		}
-		if *setFailOffPtr != "" {
-			err = setFailLed(*setFailOffPtr, "off")
-		}
-		if err != nil {
-			fmt.Println("Unable to set the disks fault LED beacon")
+	ledRequests := []struct {
+		devPath string
+		led     string
+		state   string
+	}{
+		{*setFailOnPtr, "fault", "on"},
+		{*setFailOffPtr, "fault", "off"},
+		{*setIdentOnPtr, "ident", "on"},
+		{*setIdentOffPtr, "ident", "off"},
+	}
+	ledFailed := false
+	for _, r := range ledRequests {
+		if r.devPath != "" && !printLedChange(changeLed(r.devPath, r.led, r.state)) {
+			ledFailed = true
 		}
 	}
+	if ledFailed {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,194 @@
+package main
+
+// NVMe admin commands issued through the kernel's admin passthrough ioctl.
+// The layout of nvmePassthruCmd mirrors struct nvme_passthru_cmd from
+// <linux/nvme_ioctl.h>.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	nvmeIoctlAdminCmd = 0xc0484e41
+	nvmeAdminGetLog   = 0x02
+	nvmeLogHealth     = 0x02
+	nvmeNsidAll       = 0xffffffff
+	nvmeHealthLogLen  = 512
+	nvmeDataUnitBytes = 512 * 1000
+	nvmeKelvinOffset  = 273
+)
+
+var nvmeNamespaceRe = regexp.MustCompile(`^(nvme[0-9]+)n[0-9]+$`)
+var nvmeControllerRe = regexp.MustCompile(`^nvme[0-9]+$`)
+
+var nvmeCriticalWarningText = []string{
+	"available spare below threshold",
+	"temperature threshold exceeded",
+	"reliability degraded",
+	"media placed in read only mode",
+	"volatile memory backup failed",
+	"persistent memory region read only",
+}
+
+type nvmePassthruCmd struct {
+	opcode      uint8
+	flags       uint8
+	rsvd1       uint16
+	nsid        uint32
+	cdw2        uint32
+	cdw3        uint32
+	metadata    uint64
+	addr        uint64
+	metadataLen uint32
+	dataLen     uint32
+	cdw10       uint32
+	cdw11       uint32
+	cdw12       uint32
+	cdw13       uint32
+	cdw14       uint32
+	cdw15       uint32
+	timeoutMs   uint32
+	result      uint32
+}
+
+type nvmeHealthLog struct {
+	CriticalWarning      uint8    `json:"critical_warning"`
+	CriticalWarnings     []string `json:"critical_warnings,omitempty"`
+	TemperatureCelsius   int      `json:"temperature_celsius"`
+	AvailableSpare       uint8    `json:"available_spare"`
+	AvailableSpareThresh uint8    `json:"available_spare_threshold"`
+	PercentageUsed       uint8    `json:"percentage_used"`
+	DataUnitsRead        uint64   `json:"data_units_read"`
+	DataUnitsWritten     uint64   `json:"data_units_written"`
+	PowerOnHours         uint64   `json:"power_on_hours"`
+	UnsafeShutdowns      uint64   `json:"unsafe_shutdowns"`
+	MediaErrors          uint64   `json:"media_errors"`
+	ErrorLogEntries      uint64   `json:"error_log_entries"`
+}
+
+// nvmeAdminCommand : issue an admin command to an NVMe controller or namespace node, or answer it from a capture bundle
+func nvmeAdminCommand(f *os.File, cmd *nvmePassthruCmd, data []byte) error {
+	key := nvmeAdminKey(cmd, len(data))
+	if replayingPassThrough {
+		return replayPassThrough(f, key, data)
+	}
+	err := nvmeAdminIssue(f, cmd, data)
+	recordPassThrough(f, key, data, err)
+	return err
+}
+
+// nvmeAdminIssue : send an admin command through the controller's passthrough ioctl
+func nvmeAdminIssue(f *os.File, cmd *nvmePassthruCmd, data []byte) error {
+	if len(data) > 0 {
+		cmd.addr = uint64(uintptr(unsafe.Pointer(&data[0])))
+		cmd.dataLen = uint32(len(data))
+	}
+	status, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), nvmeIoctlAdminCmd, uintptr(unsafe.Pointer(cmd)))
+	runtime.KeepAlive(data)
+	if errno != 0 {
+		return fmt.Errorf("NVMe admin command 0x%02x on %s failed: %v", cmd.opcode, f.Name(), errno)
+	}
+	if status != 0 {
+		return fmt.Errorf("NVMe admin command 0x%02x on %s returned status 0x%x", cmd.opcode, f.Name(), status)
+	}
+	return nil
+}
+
+// nvmeGetLogPage : read a log page into buf
+func nvmeGetLogPage(f *os.File, nsid uint32, logID uint8, buf []byte) error {
+	numd := uint32(len(buf)/4 - 1)
+	cmd := nvmePassthruCmd{
+		opcode: nvmeAdminGetLog,
+		nsid:   nsid,
+		cdw10:  (numd&0xffff)<<16 | uint32(logID),
+		cdw11:  numd >> 16,
+	}
+	return nvmeAdminCommand(f, &cmd, buf)
+}
+
+// nvmeControllerPath : return the /dev/nvmeX controller node behind a namespace block device
+func nvmeControllerPath(devPath string) (string, error) {
+	devName, _ := extractDev(devPath)
+	if link, err := filepath.EvalSymlinks(hostPath("/sys/class/block/" + devName + "/device")); err == nil {
+		ctrl := filepath.Base(link)
+		if _, err := os.Stat(hostPath("/dev/" + ctrl)); err == nil && nvmeControllerRe.MatchString(ctrl) {
+			return "/dev/" + ctrl, nil
+		}
+	}
+	// multipath capable kernels link the namespace to the subsystem, so fall back on the name
+	match := nvmeNamespaceRe.FindStringSubmatch(devName)
+	if match == nil {
+		return "", errors.New(devPath + " is not an NVMe namespace")
+	}
+	return "/dev/" + match[1], nil
+}
+
+// le128 : read the low 64 bits of a little endian 128 bit counter, saturating on overflow
+func le128(b []byte) uint64 {
+	if binary.LittleEndian.Uint64(b[8:16]) != 0 {
+		return ^uint64(0)
+	}
+	return binary.LittleEndian.Uint64(b[0:8])
+}
+
+// decodeNvmeHealthLog : decode the SMART / Health Information log page
+func decodeNvmeHealthLog(buf []byte) *nvmeHealthLog {
+	log := &nvmeHealthLog{
+		CriticalWarning:      buf[0],
+		TemperatureCelsius:   int(binary.LittleEndian.Uint16(buf[1:3])) - nvmeKelvinOffset,
+		AvailableSpare:       buf[3],
+		AvailableSpareThresh: buf[4],
+		PercentageUsed:       buf[5],
+		DataUnitsRead:        le128(buf[32:48]),
+		DataUnitsWritten:     le128(buf[48:64]),
+		PowerOnHours:         le128(buf[128:144]),
+		UnsafeShutdowns:      le128(buf[144:160]),
+		MediaErrors:          le128(buf[160:176]),
+		ErrorLogEntries:      le128(buf[176:192]),
+	}
+	for bit, text := range nvmeCriticalWarningText {
+		if log.CriticalWarning&(1<<uint(bit)) != 0 {
+			log.CriticalWarnings = append(log.CriticalWarnings, text)
+		}
+	}
+	return log
+}
+
+// nvmeHealthGet : fetch the health information log for an NVMe namespace
+func nvmeHealthGet(devPath string) (*nvmeHealthLog, error) {
+	ctrlPath, err := nvmeControllerPath(devPath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openPassThrough(ctrlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, nvmeHealthLogLen)
+	if err = nvmeGetLogPage(f, nvmeNsidAll, nvmeLogHealth, buf); err != nil {
+		return nil, err
+	}
+	return decodeNvmeHealthLog(buf), nil
+}
+
+// status : summarise the health log as a Good/Warn/Fail value
+func (h *nvmeHealthLog) status() string {
+	// reliability degraded, read only media and backup failures mean the drive can't be trusted
+	if h.CriticalWarning&0x1c != 0 {
+		return "Fail"
+	}
+	if h.CriticalWarning != 0 || h.PercentageUsed >= 100 || h.AvailableSpare < h.AvailableSpareThresh {
+		return "Warn"
+	}
+	return "Good"
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// nvmeHealthPage : a SMART / Health Information log page with the given bytes set
+func nvmeHealthPage(fields map[int][]byte) []byte {
+	page := make([]byte, nvmeHealthLogLen)
+	for offset, value := range fields {
+		copy(page[offset:], value)
+	}
+	return page
+}
+
+var nvmeHealthTests = []struct {
+	name   string
+	page   []byte
+	want   nvmeHealthLog
+	status string
+}{
+	{
+		name: "healthy drive",
+		page: nvmeHealthPage(map[int][]byte{
+			1:   {0x3b, 0x01}, // 315 K
+			3:   {100, 10, 3},
+			32:  {0x40, 0x42, 0x0f},       // data units read
+			48:  {0x80, 0x84, 0x1e},       // data units written
+			128: {0x10, 0x27},             // power on hours
+			144: {0x2a},                   // unsafe shutdowns
+			160: {0},                      // media errors
+			176: {0x05, 0x00, 0x00, 0x01}, // error log entries
+		}),
+		want: nvmeHealthLog{TemperatureCelsius: 42, AvailableSpare: 100, AvailableSpareThresh: 10, PercentageUsed: 3,
+			DataUnitsRead: 1000000, DataUnitsWritten: 2000000, PowerOnHours: 10000, UnsafeShutdowns: 42, ErrorLogEntries: 0x1000005},
+		status: "Good",
+	},
+	{
+		name: "spare below threshold and worn out",
+		page: nvmeHealthPage(map[int][]byte{
+			0: {0x01},
+			1: {0x5e, 0x01}, // 350 K
+			3: {5, 10, 104},
+		}),
+		want: nvmeHealthLog{CriticalWarning: 1, CriticalWarnings: []string{"available spare below threshold"},
+			TemperatureCelsius: 77, AvailableSpare: 5, AvailableSpareThresh: 10, PercentageUsed: 104},
+		status: "Warn",
+	},
+	{
+		name: "read only media, counter above 64 bits",
+		page: nvmeHealthPage(map[int][]byte{
+			0:   {0x0a},
+			1:   {0x2c, 0x01},
+			3:   {100, 10, 0},
+			160: {0x01, 0, 0, 0, 0, 0, 0, 0, 0x01},
+		}),
+		want: nvmeHealthLog{CriticalWarning: 0x0a, CriticalWarnings: []string{"temperature threshold exceeded", "media placed in read only mode"},
+			TemperatureCelsius: 27, AvailableSpare: 100, AvailableSpareThresh: 10, MediaErrors: ^uint64(0)},
+		status: "Fail",
+	},
+}
+
+func TestDecodeNvmeHealthLog(t *testing.T) {
+	for _, test := range nvmeHealthTests {
+		got := decodeNvmeHealthLog(test.page)
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("%s: decodeNvmeHealthLog =\n%+v\nwant\n%+v", test.name, *got, test.want)
+		}
+		if status := got.status(); status != test.status {
+			t.Errorf("%s: status() = %s, want %s", test.name, status, test.status)
+		}
+	}
+}
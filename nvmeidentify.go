@@ -0,0 +1,257 @@
+package main
+
+// NVMe Identify Controller / Identify Namespace decoding plus the firmware
+// slot information log, replacing `nvme id-ctrl`, `nvme id-ns` and
+// `nvme fw-log` for the fields our runbooks use.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+const (
+	nvmeIoctlID          = 0x4e40
+	nvmeAdminIdentify    = 0x06
+	nvmeIdentifyNS       = 0x00
+	nvmeIdentifyCtrl     = 0x01
+	nvmeIdentifyLen      = 4096
+	nvmeLogFirmwareSlot  = 0x03
+	nvmeFirmwareLogLen   = 512
+	nvmeProtectionBytes  = 8
+	nvmeMaxFirmwareSlots = 7
+)
+
+var nvmeOacsText = []string{
+	"security send/receive",
+	"format nvm",
+	"firmware download/commit",
+	"namespace management",
+	"device self-test",
+	"directives",
+	"nvme-mi send/receive",
+	"virtualization management",
+	"doorbell buffer config",
+	"get lba status",
+}
+
+var nvmeSanitizeText = []string{"crypto erase", "block erase", "overwrite"}
+
+var nvmeRelativePerformanceText = []string{"Best", "Better", "Good", "Degraded"}
+
+type nvmeFirmwareSlot struct {
+	Slot     int    `json:"slot"`
+	Revision string `json:"revision"`
+	Active   bool   `json:"active"`
+}
+
+type nvmeController struct {
+	Model               string             `json:"model"`
+	Serial              string             `json:"serial"`
+	Firmware            string             `json:"firmware"`
+	FirmwareSlotCount   int                `json:"firmware_slot_count"`
+	FirmwareSlot1RO     bool               `json:"firmware_slot1_read_only"`
+	ActiveFirmwareSlot  int                `json:"active_firmware_slot"`
+	NextResetSlot       int                `json:"next_reset_firmware_slot,omitempty"`
+	FirmwareSlots       []nvmeFirmwareSlot `json:"firmware_slots,omitempty"`
+	OptionalAdminCmds   []string           `json:"optional_admin_commands"`
+	SanitizeOperations  []string           `json:"sanitize_operations,omitempty"`
+	VolatileWriteCache  bool               `json:"volatile_write_cache"`
+	NamespaceCount      uint32             `json:"namespace_count"`
+	TotalCapacity       uint64             `json:"total_capacity_bytes"`
+	UnallocatedCapacity uint64             `json:"unallocated_capacity_bytes"`
+}
+
+type nvmeLBAFormat struct {
+	Index               int    `json:"index"`
+	DataSize            uint32 `json:"data_size"`
+	MetadataSize        uint16 `json:"metadata_size"`
+	ProtectionSize      uint16 `json:"protection_info_size"`
+	RelativePerformance string `json:"relative_performance"`
+	InUse               bool   `json:"in_use"`
+}
+
+type nvmeNamespace struct {
+	ID               uint32          `json:"nsid"`
+	SizeBlocks       uint64          `json:"size_blocks"`
+	CapacityBlocks   uint64          `json:"capacity_blocks"`
+	UsedBlocks       uint64          `json:"utilization_blocks"`
+	BlockSize        uint32          `json:"block_size"`
+	ProtectionType   uint8           `json:"protection_type"`
+	LBAFormats       []nvmeLBAFormat `json:"lba_formats"`
+	InUseFormat      int             `json:"in_use_format"`
+	SizeBytes        uint64          `json:"size_bytes"`
+	CapacityBytes    uint64          `json:"capacity_bytes"`
+	UtilizationBytes uint64          `json:"utilization_bytes"`
+}
+
+type nvmeIdentity struct {
+	Controller *nvmeController `json:"controller,omitempty"`
+	Namespace  *nvmeNamespace  `json:"namespace,omitempty"`
+}
+
+// nvmeString : trim the space padding from an identify string field
+func nvmeString(b []byte) string {
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
+
+// nvmeIdentify : issue an Identify command for the given CNS value
+func nvmeIdentify(f *os.File, nsid uint32, cns uint32) ([]byte, error) {
+	buf := make([]byte, nvmeIdentifyLen)
+	cmd := nvmePassthruCmd{opcode: nvmeAdminIdentify, nsid: nsid, cdw10: cns}
+	if err := nvmeAdminCommand(f, &cmd, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// nvmeNamespaceID : ask the namespace block device for its namespace id
+func nvmeNamespaceID(f *os.File) (uint32, error) {
+	buf := make([]byte, 4)
+	if replayingPassThrough {
+		err := replayPassThrough(f, "nvme-id", buf)
+		return binary.LittleEndian.Uint32(buf), err
+	}
+	nsid, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), nvmeIoctlID, 0)
+	if errno != 0 {
+		err := fmt.Errorf("NVME_IOCTL_ID on %s failed: %v", f.Name(), errno)
+		recordPassThrough(f, "nvme-id", nil, err)
+		return 0, err
+	}
+	recordPassThrough(f, "nvme-id", nsidBytes(uint32(nsid)), nil)
+	return uint32(nsid), nil
+}
+
+// decodeNvmeController : decode the Identify Controller data structure
+func decodeNvmeController(buf []byte) *nvmeController {
+	ctrl := &nvmeController{
+		Serial:              nvmeString(buf[4:24]),
+		Model:               nvmeString(buf[24:64]),
+		Firmware:            nvmeString(buf[64:72]),
+		FirmwareSlot1RO:     buf[260]&0x01 != 0,
+		FirmwareSlotCount:   int(buf[260]>>1) & 0x07,
+		VolatileWriteCache:  buf[525]&0x01 != 0,
+		NamespaceCount:      binary.LittleEndian.Uint32(buf[516:520]),
+		TotalCapacity:       le128(buf[280:296]),
+		UnallocatedCapacity: le128(buf[296:312]),
+		OptionalAdminCmds:   []string{},
+	}
+
+	oacs := binary.LittleEndian.Uint16(buf[256:258])
+	for bit, text := range nvmeOacsText {
+		if oacs&(1<<uint(bit)) != 0 {
+			ctrl.OptionalAdminCmds = append(ctrl.OptionalAdminCmds, text)
+		}
+	}
+	sanicap := binary.LittleEndian.Uint32(buf[328:332])
+	for bit, text := range nvmeSanitizeText {
+		if sanicap&(1<<uint(bit)) != 0 {
+			ctrl.SanitizeOperations = append(ctrl.SanitizeOperations, text)
+		}
+	}
+	if len(ctrl.SanitizeOperations) > 0 {
+		ctrl.OptionalAdminCmds = append(ctrl.OptionalAdminCmds, "sanitize")
+	}
+	return ctrl
+}
+
+// decodeNvmeFirmwareLog : add the firmware slot information log to the controller data
+func decodeNvmeFirmwareLog(ctrl *nvmeController, buf []byte) {
+	ctrl.ActiveFirmwareSlot = int(buf[0] & 0x07)
+	ctrl.NextResetSlot = int(buf[0]>>4) & 0x07
+	for slot := 1; slot <= nvmeMaxFirmwareSlots && slot <= ctrl.FirmwareSlotCount; slot++ {
+		revision := nvmeString(buf[8*slot : 8*slot+8])
+		if revision == "" {
+			continue
+		}
+		ctrl.FirmwareSlots = append(ctrl.FirmwareSlots, nvmeFirmwareSlot{
+			Slot:     slot,
+			Revision: revision,
+			Active:   slot == ctrl.ActiveFirmwareSlot,
+		})
+	}
+}
+
+// decodeNvmeNamespace : decode the Identify Namespace data structure
+func decodeNvmeNamespace(nsid uint32, buf []byte) *nvmeNamespace {
+	ns := &nvmeNamespace{
+		ID:             nsid,
+		SizeBlocks:     binary.LittleEndian.Uint64(buf[0:8]),
+		CapacityBlocks: binary.LittleEndian.Uint64(buf[8:16]),
+		UsedBlocks:     binary.LittleEndian.Uint64(buf[16:24]),
+		ProtectionType: buf[29] & 0x07,
+	}
+
+	formats := int(buf[25]) + 1
+	flbas := buf[26]
+	ns.InUseFormat = int(flbas & 0x0f)
+	if formats > 16 {
+		ns.InUseFormat |= int(flbas>>5&0x03) << 4
+	}
+	piCapable := buf[28]&0x07 != 0
+
+	for i := 0; i < formats; i++ {
+		lbaf := binary.LittleEndian.Uint32(buf[128+4*i:])
+		format := nvmeLBAFormat{
+			Index:               i,
+			MetadataSize:        uint16(lbaf),
+			DataSize:            1 << ((lbaf >> 16) & 0xff),
+			RelativePerformance: nvmeRelativePerformanceText[(lbaf>>24)&0x03],
+			InUse:               i == ns.InUseFormat,
+		}
+		if piCapable && format.MetadataSize >= nvmeProtectionBytes {
+			format.ProtectionSize = nvmeProtectionBytes
+		}
+		ns.LBAFormats = append(ns.LBAFormats, format)
+		if format.InUse {
+			ns.BlockSize = format.DataSize
+		}
+	}
+
+	ns.SizeBytes = ns.SizeBlocks * uint64(ns.BlockSize)
+	ns.CapacityBytes = ns.CapacityBlocks * uint64(ns.BlockSize)
+	ns.UtilizationBytes = ns.UsedBlocks * uint64(ns.BlockSize)
+	return ns
+}
+
+// nvmeIdentityGet : decode Identify Controller and Identify Namespace for an NVMe namespace
+func nvmeIdentityGet(devPath string) (*nvmeIdentity, error) {
+	ctrlPath, err := nvmeControllerPath(devPath)
+	if err != nil {
+		return nil, err
+	}
+	ctrlFile, err := openPassThrough(ctrlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer ctrlFile.Close()
+
+	identity := &nvmeIdentity{}
+	buf, err := nvmeIdentify(ctrlFile, 0, nvmeIdentifyCtrl)
+	if err != nil {
+		return nil, err
+	}
+	identity.Controller = decodeNvmeController(buf)
+
+	fwLog := make([]byte, nvmeFirmwareLogLen)
+	if err = nvmeGetLogPage(ctrlFile, nvmeNsidAll, nvmeLogFirmwareSlot, fwLog); err == nil {
+		decodeNvmeFirmwareLog(identity.Controller, fwLog)
+	}
+
+	nsFile, err := openPassThrough(devPath)
+	if err != nil {
+		return identity, nil
+	}
+	defer nsFile.Close()
+
+	nsid, err := nvmeNamespaceID(nsFile)
+	if err != nil {
+		return identity, nil
+	}
+	if buf, err = nvmeIdentify(nsFile, nsid, nvmeIdentifyNS); err == nil {
+		identity.Namespace = decodeNvmeNamespace(nsid, buf)
+	}
+	return identity, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// nvmeData : an NVMe data structure of the given length with the given bytes set
+func nvmeData(length int, fields map[int][]byte) []byte {
+	buf := make([]byte, length)
+	for offset, value := range fields {
+		copy(buf[offset:], value)
+	}
+	return buf
+}
+
+var nvmeControllerTests = []struct {
+	name  string
+	ctrl  []byte
+	fwLog []byte
+	want  nvmeController
+}{
+	{
+		name: "three firmware slots, sanitize supported",
+		ctrl: nvmeData(nvmeIdentifyLen, map[int][]byte{
+			4:   []byte("S4EWNX0R123456      "),
+			24:  []byte("Samsung SSD 970 EVO Plus 1TB            "),
+			64:  []byte("2B2QEXM7"),
+			256: {0x17, 0x00},                   // oacs
+			260: {0x07},                         // frmw
+			280: {0x00, 0x60, 0xdb, 0xe0, 0xe8}, // tnvmcap
+			328: {0x03, 0x00, 0x00, 0x00},       // sanicap
+			516: {0x20, 0x00, 0x00, 0x00},       // nn
+			525: {0x01},                         // vwc
+		}),
+		fwLog: nvmeData(nvmeFirmwareLogLen, map[int][]byte{
+			0:  {0x21},
+			8:  []byte("2B2QEXM7"),
+			16: []byte("2B2QEXM6"),
+		}),
+		want: nvmeController{Model: "Samsung SSD 970 EVO Plus 1TB", Serial: "S4EWNX0R123456", Firmware: "2B2QEXM7",
+			FirmwareSlotCount: 3, FirmwareSlot1RO: true, ActiveFirmwareSlot: 1, NextResetSlot: 2,
+			FirmwareSlots:      []nvmeFirmwareSlot{{Slot: 1, Revision: "2B2QEXM7", Active: true}, {Slot: 2, Revision: "2B2QEXM6"}},
+			OptionalAdminCmds:  []string{"security send/receive", "format nvm", "firmware download/commit", "device self-test", "sanitize"},
+			SanitizeOperations: []string{"crypto erase", "block erase"},
+			VolatileWriteCache: true, NamespaceCount: 32, TotalCapacity: 1000204886016},
+	},
+	{
+		name: "revisions beyond the slot count ignored",
+		ctrl: nvmeData(nvmeIdentifyLen, map[int][]byte{
+			4:   []byte("PHLJ123400AB1P0FGN  "),
+			24:  []byte("INTEL SSDPE2KX010T8"),
+			64:  []byte("VDV10131"),
+			260: {0x02},
+			296: {0x00, 0x10},
+			516: {0x80, 0x00, 0x00, 0x00},
+		}),
+		fwLog: nvmeData(nvmeFirmwareLogLen, map[int][]byte{
+			0:  {0x01},
+			8:  []byte("VDV10131"),
+			16: []byte("VDV10170"),
+		}),
+		want: nvmeController{Model: "INTEL SSDPE2KX010T8", Serial: "PHLJ123400AB1P0FGN", Firmware: "VDV10131",
+			FirmwareSlotCount: 1, ActiveFirmwareSlot: 1,
+			FirmwareSlots:     []nvmeFirmwareSlot{{Slot: 1, Revision: "VDV10131", Active: true}},
+			OptionalAdminCmds: []string{}, NamespaceCount: 128, UnallocatedCapacity: 4096},
+	},
+}
+
+func TestDecodeNvmeController(t *testing.T) {
+	for _, test := range nvmeControllerTests {
+		got := decodeNvmeController(test.ctrl)
+		decodeNvmeFirmwareLog(got, test.fwLog)
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("%s: decodeNvmeController =\n%+v\nwant\n%+v", test.name, *got, test.want)
+		}
+	}
+}
+
+func TestDecodeNvmeNamespace(t *testing.T) {
+	buf := nvmeData(nvmeIdentifyLen, map[int][]byte{
+		0:   {0xb0, 0x6d, 0x70, 0x74}, // nsze
+		8:   {0xb0, 0x6d, 0x70, 0x74}, // ncap
+		16:  {0x00, 0x10},             // nuse
+		25:  {0x01},                   // nlbaf
+		26:  {0x01},                   // flbas
+		28:  {0x01},                   // dpc
+		29:  {0x01},                   // dps
+		128: {0x00, 0x00, 0x09, 0x02},
+		132: {0x08, 0x00, 0x0c, 0x00},
+	})
+	want := nvmeNamespace{ID: 1, SizeBlocks: 1953525168, CapacityBlocks: 1953525168, UsedBlocks: 4096,
+		BlockSize: 4096, ProtectionType: 1, InUseFormat: 1,
+		LBAFormats: []nvmeLBAFormat{
+			{Index: 0, DataSize: 512, RelativePerformance: "Good"},
+			{Index: 1, DataSize: 4096, MetadataSize: 8, ProtectionSize: 8, RelativePerformance: "Best", InUse: true},
+		},
+		SizeBytes: 1953525168 * 4096, CapacityBytes: 1953525168 * 4096, UtilizationBytes: 4096 * 4096}
+	if got := decodeNvmeNamespace(1, buf); !reflect.DeepEqual(*got, want) {
+		t.Errorf("decodeNvmeNamespace =\n%+v\nwant\n%+v", *got, want)
+	}
+
+	// with more than 16 formats flbas bits 5-6 hold the upper bits of the format index
+	buf = nvmeData(nvmeIdentifyLen, map[int][]byte{
+		0:              {0x00, 0x00, 0x01},
+		25:             {19},
+		26:             {0x22},
+		128 + 4*18 + 2: {0x0c},
+	})
+	got := decodeNvmeNamespace(2, buf)
+	if got.InUseFormat != 18 || len(got.LBAFormats) != 20 || got.BlockSize != 4096 || got.SizeBytes != 0x10000*4096 {
+		t.Errorf("decodeNvmeNamespace with 20 formats = format %d of %d, block size %d, %d bytes",
+			got.InUseFormat, len(got.LBAFormats), got.BlockSize, got.SizeBytes)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+// LEDs of NVMe drives without an SES enclosure. U.2/U.3 bays usually light
+// them through the PCIe hotplug slot's attention indicator
+// (/sys/bus/pci/slots/*/attention: 0 off, 1 on, 2 blink) or, on newer
+// platforms, through NPEM/_DSM, which the kernel exposes as LED class devices
+// named <bdf>:enclosure:<indication>. Both are used when the backend can't set
+// or read a drive's LEDs; the attention indicator is shared, so ident is shown
+// as blinking and fault as steady on.
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	lsm "github.com/libstorage/libstoragemgmt-golang"
+)
+
+const (
+	ledMechanismNpem = "npem"
+	ledMechanismSlot = "pcie-slot-attention"
+)
+
+// NPEM indications used for each LED
+var npemIndication = map[string]string{
+	"ident": "locate",
+	"fault": "fail",
+}
+
+// slot attention values for each LED
+var slotAttention = map[string]string{
+	"ident": "2",
+	"fault": "1",
+}
+
+var pciAddressPattern = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+// isNvme : true for NVMe namespaces
+func isNvme(devPath string) bool {
+	devName, _ := extractDev(devPath)
+	return strings.HasPrefix(devName, "nvme")
+}
+
+// pciFunctions : the PCI addresses between a block device and the root port, nearest first
+func pciFunctions(devPath string) []string {
+	realPath, err := deviceRealPath(devPath)
+	if err != nil {
+		return nil
+	}
+	var functions []string
+	components := strings.Split(realPath, "/")
+	for i := len(components) - 1; i >= 0; i-- {
+		if pciAddressPattern.MatchString(components[i]) {
+			functions = append(functions, components[i])
+		}
+	}
+	return functions
+}
+
+// pciSlot : the hotplug slot directory holding the NVMe controller, matched on the slot address
+func pciSlot(devPath string) (string, error) {
+	functions := pciFunctions(devPath)
+	slots, _ := filepath.Glob(hostPath("/sys/bus/pci/slots/*"))
+	for _, function := range functions {
+		for _, slot := range slots {
+			address, err := ioutil.ReadFile(filepath.Join(slot, "address"))
+			if err != nil {
+				continue
+			}
+			// slot addresses leave out the function number
+			if strings.TrimSpace(string(address)) == function[:len(function)-2] {
+				return slot, nil
+			}
+		}
+	}
+	return "", errors.New("no PCIe hotplug slot found for " + devPath)
+}
+
+// npemLed : the NPEM LED class device of an indication, on the drive or the port above it
+func npemLed(devPath string, indication string) (string, error) {
+	for _, function := range pciFunctions(devPath) {
+		path := hostPath("/sys/class/leds/" + function + ":enclosure:" + indication)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("no NPEM " + indication + " LED found for " + devPath)
+}
+
+// nvmeLedStatus : the LED status from NPEM or the hotplug slot, and the mechanism it came from
+func nvmeLedStatus(devPath string) (lsm.DiskLedStatusBitField, string, error) {
+	var status lsm.DiskLedStatusBitField
+	found := false
+	for _, led := range ledNames {
+		path, err := npemLed(devPath, npemIndication[led])
+		if err != nil {
+			continue
+		}
+		found = true
+		bits := ledStatusBits[led]
+		brightness, err := ioutil.ReadFile(filepath.Join(path, "brightness"))
+		switch {
+		case err != nil:
+			status |= bits.unknown
+		case strings.TrimSpace(string(brightness)) == "0":
+			status |= bits.off
+		default:
+			status |= bits.on
+		}
+	}
+	if found {
+		return status, ledMechanismNpem, nil
+	}
+
+	slot, err := pciSlot(devPath)
+	if err != nil {
+		return lsm.DiskLedStatusUnknown, "", err
+	}
+	attention, err := ioutil.ReadFile(filepath.Join(slot, "attention"))
+	if err != nil {
+		return lsm.DiskLedStatusUnknown, "", errors.New("the PCIe slot of " + devPath + " has no attention indicator")
+	}
+	switch strings.TrimSpace(string(attention)) {
+	case "0":
+		status = lsm.DiskLedStatusIdentOff | lsm.DiskLedStatusFaultOff
+	case "1":
+		status = lsm.DiskLedStatusIdentOff | lsm.DiskLedStatusFaultOn
+	case "2":
+		status = lsm.DiskLedStatusIdentOn | lsm.DiskLedStatusFaultOff
+	default:
+		status = lsm.DiskLedStatusIdentUnknown | lsm.DiskLedStatusFaultUnknown
+	}
+	return status, ledMechanismSlot, nil
+}
+
+// nvmeSetLed : set a LED through NPEM or the hotplug slot, returning the mechanism used
+func nvmeSetLed(devPath string, led string, state string) (string, error) {
+	value := "0"
+	if state == "on" {
+		value = "1"
+	}
+	if path, err := npemLed(devPath, npemIndication[led]); err == nil {
+		return ledMechanismNpem, ioutil.WriteFile(filepath.Join(path, "brightness"), []byte(value), 0644)
+	}
+
+	slot, err := pciSlot(devPath)
+	if err != nil {
+		return "", errors.New("no NPEM LEDs or PCIe hotplug slot found for " + devPath)
+	}
+	attention := filepath.Join(slot, "attention")
+	current, err := ioutil.ReadFile(attention)
+	if err != nil {
+		return "", errors.New("the PCIe slot of " + devPath + " has no attention indicator")
+	}
+	if state == "on" && led == "ident" && strings.TrimSpace(string(current)) == slotAttention["fault"] {
+		return ledMechanismSlot, errors.New("the slot's attention indicator is showing a fault, blinking it would hide that")
+	}
+	if state == "on" {
+		value = slotAttention[led]
+	} else if strings.TrimSpace(string(current)) != slotAttention[led] {
+		// the indicator shows the other LED, leave it be
+		return ledMechanismSlot, nil
+	}
+	return ledMechanismSlot, ioutil.WriteFile(attention, []byte(value), 0644)
+}
+
+// diskLedStatus : the LED status from the backend, or for NVMe drives it can't see, from NPEM or the hotplug slot
+func diskLedStatus(devPath string) (lsm.DiskLedStatusBitField, error) {
+	status, err := backend.ledStatus(devPath)
+	if isNvme(devPath) && (err != nil || status == 0 || status&lsm.DiskLedStatusUnknown != 0) {
+		if nvmeStatus, _, nvmeErr := nvmeLedStatus(devPath); nvmeErr == nil {
+			return nvmeStatus, nil
+		}
+	}
+	return status, err
+}
@@ -0,0 +1,113 @@
+package main
+
+// LEDs of block devices that are hardware RAID volumes. The localdisk API
+// can't see the slots behind a RAID controller, so for disks on a megaraid_sas
+// (PERC, MegaRAID) or hpsa/smartpqi (Smart Array) host the LED commands ask
+// the matching libstoragemgmt plugin through lsmd instead. The plugin API only
+// has an ident LED per volume, which lights every member disk of the volume;
+// there is no call for a single member disk or for fault LEDs.
+
+import (
+	"errors"
+	"strings"
+
+	lsm "github.com/libstorage/libstoragemgmt-golang"
+)
+
+// libstoragemgmt plugins by SCSI host driver
+var raidPlugins = map[string]string{
+	"megaraid_sas": "megaraid://",
+	"hpsa":         "hpsa://",
+	"smartpqi":     "hpsa://",
+}
+
+// plugin calls are slow on big controllers
+const raidPluginTimeout = 30000
+
+// isRaidPlugin : true when a LED mechanism is one of the RAID plugin URIs
+func isRaidPlugin(mechanism string) bool {
+	for _, uri := range raidPlugins {
+		if mechanism == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// scsiHostDriver : the driver of the SCSI host a block device hangs off
+func scsiHostDriver(devPath string) string {
+	realPath, err := deviceRealPath(devPath)
+	if err != nil {
+		return ""
+	}
+	for _, component := range strings.Split(realPath, "/") {
+		if strings.HasPrefix(component, "host") {
+			driver, _ := readFile("/sys/class/scsi_host/" + component + "/proc_name")
+			return driver
+		}
+	}
+	return ""
+}
+
+// raidPluginURI : the plugin for the controller behind a block device, empty when it isn't on a RAID controller
+func raidPluginURI(devPath string) string {
+	return raidPlugins[scsiHostDriver(devPath)]
+}
+
+// raidVolume : the plugin connection and volume backing a block device, matched on VPD 0x83
+func raidVolume(devPath string, uri string) (*lsm.ClientConnection, *lsm.Volume, error) {
+	vpd83, err := sysfsBackend{}.vpd83(devPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := lsm.Client(uri, "", raidPluginTimeout)
+	if err != nil {
+		return nil, nil, errors.New("unable to reach the " + uri + " plugin (is lsmd running?): " + err.Error())
+	}
+	volumes, err := c.Volumes()
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	for i := range volumes {
+		if strings.EqualFold(volumes[i].Vpd83, vpd83) {
+			return c, &volumes[i], nil
+		}
+	}
+	c.Close()
+	return nil, nil, errors.New(devPath + " is not a volume of the " + uri + " controller")
+}
+
+// raidIdentLed : switch the ident LED of a RAID volume, returning false when the device isn't a volume
+func raidIdentLed(devPath string, uri string, state string) (bool, error) {
+	c, vol, err := raidVolume(devPath, uri)
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+
+	if state == "on" {
+		return true, c.VolIdentLedOn(vol)
+	}
+	return true, c.VolIdentLedOff(vol)
+}
+
+// raidFaultLed : fault LEDs of RAID volumes can't be driven through the plugins
+func raidFaultLed(devPath string, uri string) (bool, error) {
+	c, vol, err := raidVolume(devPath, uri)
+	if err != nil {
+		return false, err
+	}
+	c.Close()
+	return true, errors.New("the " + uri + " plugin has no fault LED call, only the ident LED of volume " + vol.Name + " can be set")
+}
+
+// raidFallback : set a LED through the backend when the device isn't a RAID volume,
+// keeping the reason the plugin wasn't used when the backend fails too
+func raidFallback(raidErr error, set func() error) error {
+	err := set()
+	if err != nil && raidErr != nil {
+		return errors.New(err.Error() + "; " + raidErr.Error())
+	}
+	return err
+}
@@ -0,0 +1,440 @@
+package main
+
+// Redaction of identifying values (serial numbers, WWIDs/VPD83 ids, NVMe
+// namespace ids, filesystem and LUKS UUIDs, host names) for inventories and
+// capture bundles that leave the site. Pseudonyms are derived with an HMAC
+// keyed by a secret kept in a local file, so the same value always gets the
+// same pseudonym. They keep the length and character classes of the original
+// so the binary forms inside raw VPD/IDENTIFY pages can be replaced in place.
+// In the default reversible mode the file also keeps a pseudonym -> original
+// mapping; -redact-oneway only keeps the key.
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	redactSerial = "serial"
+	redactID     = "id"
+	redactHost   = "host"
+
+	// shorter values are too likely to match unrelated text
+	redactMinLength       = 4
+	redactMinBinaryLength = 6
+)
+
+// udev properties holding values to redact in capture bundles
+var redactUdevProperties = map[string]string{
+	"ID_SERIAL_SHORT":       redactSerial,
+	"ID_SCSI_SERIAL":        redactSerial,
+	"ID_WWN":                redactID,
+	"ID_WWN_WITH_EXTENSION": redactID,
+	"ID_FS_UUID":            redactID,
+	"ID_FS_UUID_ENC":        redactID,
+	"ID_FS_UUID_SUB":        redactID,
+	"ID_FS_UUID_SUB_ENC":    redactID,
+	"ID_PART_ENTRY_UUID":    redactID,
+	"ID_PART_TABLE_UUID":    redactID,
+}
+
+// sysfs attributes of block devices and NVMe controllers holding values to redact
+var redactSysfsAttributes = map[string]string{
+	"serial":        redactSerial,
+	"device/serial": redactSerial,
+	"nguid":         redactID,
+	"eui":           redactID,
+	"uuid":          redactID,
+}
+
+// values collectors report in place of an identifier they couldn't read
+var redactPlaceholders = map[string]bool{
+	lsmUnavailableText: true,
+	"unknown":          true,
+	"unavailable":      true,
+	"not available":    true,
+	"none":             true,
+	"n/a":              true,
+}
+
+var redactEnabled bool
+var redactOneWay bool
+var redactFile = "localdisk-redact.json"
+
+type redactState struct {
+	Key     []byte            `json:"key"`
+	Mapping map[string]string `json:"mapping,omitempty"`
+}
+
+type redactor struct {
+	state   redactState
+	oneWay  bool
+	file    string
+	text    map[string]string
+	binary  map[string]string
+	changed bool
+}
+
+var activeRedactor *redactor
+
+// addRedactFlags : register the redaction flags on a flag set
+func addRedactFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&redactEnabled, "redact", false, "mask serial numbers, WWIDs, VPD83 ids, UUIDs and host names with consistent pseudonyms")
+	fs.BoolVar(&redactOneWay, "redact-oneway", false, "with -redact, don't keep the pseudonym to original mapping")
+	fs.StringVar(&redactFile, "redact-file", "localdisk-redact.json", "with -redact, the local file holding the redaction key and mapping")
+}
+
+// initRedactor : load or create the redaction key when -redact is given
+func initRedactor() error {
+	if !redactEnabled {
+		return nil
+	}
+	r := &redactor{
+		oneWay: redactOneWay,
+		file:   redactFile,
+		text:   make(map[string]string),
+		binary: make(map[string]string),
+	}
+	content, err := ioutil.ReadFile(redactFile)
+	switch {
+	case err == nil:
+		if err = json.Unmarshal(content, &r.state); err != nil {
+			return errors.New("Unable to read redaction file " + redactFile + ": " + err.Error())
+		}
+	case os.IsNotExist(err):
+		r.state.Key = make([]byte, 32)
+		if _, err = rand.Read(r.state.Key); err != nil {
+			return err
+		}
+		r.changed = true
+	default:
+		return err
+	}
+	if len(r.state.Key) == 0 {
+		return errors.New("Redaction file " + redactFile + " has no key")
+	}
+	if r.state.Mapping == nil {
+		r.state.Mapping = make(map[string]string)
+	}
+	activeRedactor = r
+	return nil
+}
+
+// saveRedactor : write the key and, unless one-way, the mapping back to the redaction file
+func saveRedactor() error {
+	r := activeRedactor
+	if r == nil || !r.changed {
+		return nil
+	}
+	state := r.state
+	if r.oneWay {
+		state.Mapping = nil
+	}
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.file, content, 0600)
+}
+
+// isHexID : true for identifiers made of hex digits and separators only
+func isHexID(value string) bool {
+	digits := 0
+	for _, c := range value {
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+			digits++
+		case c == '-' || c == ':':
+		default:
+			return false
+		}
+	}
+	return digits > 0
+}
+
+// pseudonym : derive a replacement with the same length and character classes
+func (r *redactor) pseudonym(kind string, value string) string {
+	mac := hmac.New(sha256.New, r.state.Key)
+	mac.Write([]byte(kind + "\x00" + value))
+	stream := mac.Sum(nil)
+	for len(stream) < 2*len(value) {
+		mac.Write(stream[len(stream)-sha256.Size:])
+		stream = mac.Sum(stream)
+	}
+
+	hexID := kind == redactID && isHexID(value)
+	out := []byte(value)
+	for i := range out {
+		b := stream[i]
+		c := out[i]
+		switch {
+		case hexID && i == 0 && len(value) >= 16:
+			// keep the NAA format nibble so the identifier still decodes
+		case hexID && c >= '0' && c <= '9', hexID && c >= 'a' && c <= 'f':
+			out[i] = "0123456789abcdef"[b%16]
+		case hexID && c >= 'A' && c <= 'F':
+			out[i] = "0123456789ABCDEF"[b%16]
+		case c >= '0' && c <= '9':
+			out[i] = '0' + b%10
+		case c >= 'a' && c <= 'z':
+			out[i] = 'a' + b%26
+		case c >= 'A' && c <= 'Z':
+			out[i] = 'A' + b%26
+		}
+	}
+	return string(out)
+}
+
+// swapWords : byte swap each 16 bit word, as ATA strings and WWNs are stored
+func swapWords(b []byte) []byte {
+	if len(b)%2 != 0 {
+		b = append(b, ' ')
+	}
+	out := make([]byte, len(b))
+	for i := 0; i+1 < len(b); i += 2 {
+		out[i], out[i+1] = b[i+1], b[i]
+	}
+	return out
+}
+
+// reformat : lay a canonical hex pseudonym out like the original, keeping separators and case
+func reformat(value string, canonical string) string {
+	out := []byte(value)
+	j := 0
+	for i, c := range out {
+		if c == '-' || c == ':' {
+			continue
+		}
+		out[i] = canonical[j]
+		if c >= 'A' && c <= 'F' {
+			out[i] = strings.ToUpper(canonical[j : j+1])[0]
+		}
+		j++
+	}
+	return string(out)
+}
+
+// add : remember the textual and binary forms of a value and its pseudonym
+func (r *redactor) add(kind string, value string) {
+	if len(value) < redactMinLength {
+		return
+	}
+	if _, ok := r.text[value]; ok {
+		return
+	}
+
+	var p string
+	if kind == redactID {
+		// derive from the canonical form so 0x/naa./dashed/upper case variants agree
+		canonical := strings.ToLower(strings.NewReplacer("-", "", ":", "").Replace(value))
+		pc := r.pseudonym(kind, canonical)
+		p = reformat(value, pc)
+		r.text[canonical] = pc
+		r.text[strings.ToUpper(canonical)] = strings.ToUpper(pc)
+		raw, err1 := hex.DecodeString(canonical)
+		praw, err2 := hex.DecodeString(pc)
+		if err1 == nil && err2 == nil && len(raw) >= redactMinBinaryLength {
+			r.binary[string(raw)] = string(praw)
+			r.binary[string(swapWords(raw))] = string(swapWords(praw))
+		}
+	} else {
+		p = r.pseudonym(kind, value)
+		if len(value) >= redactMinBinaryLength {
+			r.binary[string(swapWords([]byte(value)))] = string(swapWords([]byte(p)))
+		}
+	}
+	r.text[value] = p
+	r.remember(p, value)
+}
+
+// remember : keep the pseudonym -> original mapping unless running one-way
+func (r *redactor) remember(p string, value string) {
+	if r.oneWay {
+		return
+	}
+	if original, ok := r.state.Mapping[p]; !ok || original != value {
+		r.state.Mapping[p] = value
+		r.changed = true
+	}
+}
+
+// register : note a sensitive value so every later output masks it
+func (r *redactor) register(kind string, value string) {
+	if r == nil {
+		return
+	}
+	value = strings.TrimSpace(value)
+	if redactPlaceholders[strings.ToLower(value)] {
+		return
+	}
+	if kind == redactID {
+		// 0x5000c500..., naa.5000c500..., eui.0025...
+		for _, prefix := range []string{"0x", "naa.", "eui."} {
+			value = strings.TrimPrefix(value, prefix)
+		}
+		if !isHexID(value) {
+			kind = redactSerial
+		}
+	}
+	r.add(kind, value)
+}
+
+// replacePairs : longest first so a value inside a longer one doesn't win
+func replacePairs(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, k, m[k])
+	}
+	return pairs
+}
+
+// String : mask every registered value in a string
+func (r *redactor) String(s string) string {
+	if r == nil || len(r.text) == 0 {
+		return s
+	}
+	return strings.NewReplacer(replacePairs(r.text)...).Replace(s)
+}
+
+// Bytes : mask the textual and binary forms of every registered value in raw data
+func (r *redactor) Bytes(b []byte) []byte {
+	if r == nil || len(b) == 0 {
+		return b
+	}
+	out := []byte(r.String(string(b)))
+	pairs := replacePairs(r.binary)
+	for i := 0; i < len(pairs); i += 2 {
+		out = bytes.Replace(out, []byte(pairs[i]), []byte(pairs[i+1]), -1)
+	}
+	return out
+}
+
+// registerWwid : note a WWID; a t10 one is vendor, model and serial, and only its serial is identifying
+func (r *redactor) registerWwid(wwid string) {
+	wwid = strings.TrimSpace(wwid)
+	if strings.HasPrefix(wwid, "t10.") {
+		r.registerT10(strings.TrimPrefix(wwid, "t10."))
+		return
+	}
+	r.register(redactID, wwid)
+}
+
+// registerT10 : note the serial at the end of a T10 vendor ID, so it gets the serial's pseudonym
+func (r *redactor) registerT10(id string) {
+	if fields := strings.Fields(id); len(fields) > 1 {
+		r.register(redactSerial, fields[len(fields)-1])
+	}
+}
+
+// registerDisk : note every identifying value the collectors found for a disk
+func (r *redactor) registerDisk(d *disk) {
+	if r == nil {
+		return
+	}
+	r.register(redactSerial, d.serialNumber)
+	r.register(redactID, d.vpd83)
+	r.registerWwid(d.wwid)
+	if d.vpd != nil {
+		r.register(redactSerial, d.vpd.UnitSerial)
+		for _, designator := range d.vpd.Designators {
+			switch {
+			case designator.Association != associationText[0]:
+			case designator.Type == designatorTypeText[designatorTypeT10]:
+				r.registerT10(designator.Value)
+			default:
+				r.register(redactID, designator.Value)
+			}
+		}
+	}
+	if d.ataIdentity != nil {
+		r.register(redactSerial, d.ataIdentity.Serial)
+		r.register(redactID, d.ataIdentity.WWN)
+	}
+	if d.nvmeIdentity != nil && d.nvmeIdentity.Controller != nil {
+		r.register(redactSerial, d.nvmeIdentity.Controller.Serial)
+	}
+	devName, _ := extractDev(d.devPath)
+	for attr, kind := range redactSysfsAttributes {
+		if value, err := readFile("/sys/class/block/" + devName + "/" + attr); err == nil {
+			r.register(kind, value)
+		}
+	}
+}
+
+// redactDisk : replace the identifying fields of a disk with their pseudonyms
+func (r *redactor) redactDisk(d *disk) {
+	if r == nil {
+		return
+	}
+	d.serialNumber = r.String(d.serialNumber)
+	d.vpd83 = r.String(d.vpd83)
+	d.wwid = r.String(d.wwid)
+	if d.vpd != nil {
+		vpd := *d.vpd
+		vpd.UnitSerial = r.String(vpd.UnitSerial)
+		vpd.Designators = nil
+		for _, designator := range d.vpd.Designators {
+			designator.Value = r.String(designator.Value)
+			vpd.Designators = append(vpd.Designators, designator)
+		}
+		d.vpd = &vpd
+	}
+	if d.ataIdentity != nil {
+		id := *d.ataIdentity
+		id.Serial = r.String(id.Serial)
+		id.WWN = r.String(id.WWN)
+		d.ataIdentity = &id
+	}
+	if d.nvmeIdentity != nil && d.nvmeIdentity.Controller != nil {
+		ctrl := *d.nvmeIdentity.Controller
+		ctrl.Serial = r.String(ctrl.Serial)
+		d.nvmeIdentity = &nvmeIdentity{Controller: &ctrl, Namespace: d.nvmeIdentity.Namespace}
+	}
+}
+
+// registerUdev : note the serials, WWNs and filesystem/LUKS UUIDs in a udev database entry
+func (r *redactor) registerUdev(content string) {
+	if r == nil {
+		return
+	}
+	for _, line := range strings.Split(content, "\n") {
+		kv := strings.SplitN(strings.TrimPrefix(line, "E:"), "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(line, "E:") {
+			continue
+		}
+		if kind, ok := redactUdevProperties[kv[0]]; ok {
+			r.register(kind, kv[1])
+		}
+	}
+}
+
+// hostName : a host name, masked when redacting
+func (r *redactor) hostName(name string) string {
+	if r == nil || name == "" {
+		return name
+	}
+	// host names are only replaced where they are reported, short ones would match unrelated text
+	p := r.pseudonym(redactHost, name)
+	r.remember(p, name)
+	return p
+}
@@ -0,0 +1,316 @@
+package main
+
+// Recording and replay of everything a capture bundle can't get from the
+// filesystem alone: the pass-through command responses (SMART, VPD, log
+// pages, NVMe admin commands) and the backend's answers. A capture records
+// them while running the inventory; -root on an extracted bundle replays
+// them so the output matches what the live host produced.
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	lsm "github.com/libstorage/libstoragemgmt-golang"
+)
+
+const (
+	bundleManifest    = "manifest.json"
+	bundleBackend     = "backend.json"
+	bundlePassThrough = "passthrough.json"
+)
+
+type passThroughRecord struct {
+	Data  []byte `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// keyed by device name, then by command
+var passThroughLog map[string]map[string]passThroughRecord
+var recordingPassThrough bool
+var replayingPassThrough bool
+
+type recordedDisk struct {
+	Serial    string                    `json:"serial"`
+	Vpd83     string                    `json:"vpd83"`
+	Health    lsm.DiskHealthStatus      `json:"health"`
+	Rpm       int32                     `json:"rpm"`
+	LinkType  lsm.DiskLinkType          `json:"link_type"`
+	LinkSpeed uint32                    `json:"link_speed"`
+	LedStatus lsm.DiskLedStatusBitField `json:"led_status"`
+	Errors    map[string]string         `json:"errors,omitempty"`
+}
+
+type recordedInventory struct {
+	Backend        string                   `json:"backend"`
+	AvailableError string                   `json:"available_error,omitempty"`
+	Disks          []string                 `json:"disks"`
+	ListError      string                   `json:"list_error,omitempty"`
+	Devices        map[string]*recordedDisk `json:"devices"`
+}
+
+var replayInventory *recordedInventory
+
+// passThroughDevice : the device name a pass-through handle was opened on
+func passThroughDevice(f *os.File) string {
+	return filepath.Base(f.Name())
+}
+
+// recordPassThrough : keep a command's response while capturing
+func recordPassThrough(f *os.File, command string, data []byte, err error) {
+	if !recordingPassThrough {
+		return
+	}
+	dev := passThroughDevice(f)
+	if passThroughLog[dev] == nil {
+		passThroughLog[dev] = make(map[string]passThroughRecord)
+	}
+	record := passThroughRecord{Data: append([]byte(nil), data...)}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	passThroughLog[dev][command] = record
+}
+
+// replayPassThrough : answer a command from a capture instead of the device
+func replayPassThrough(f *os.File, command string, data []byte) error {
+	record, ok := passThroughLog[passThroughDevice(f)][command]
+	if !ok {
+		return errors.New("command " + command + " was not captured for " + passThroughDevice(f))
+	}
+	copy(data, record.Data)
+	if record.Error != "" {
+		return errors.New(record.Error)
+	}
+	return nil
+}
+
+// sgioKey : identify an SG_IO command in a capture
+func sgioKey(cdb []byte, dataLen int) string {
+	return fmt.Sprintf("sg:%x:%d", cdb, dataLen)
+}
+
+// nvmeAdminKey : identify an NVMe admin command in a capture
+func nvmeAdminKey(cmd *nvmePassthruCmd, dataLen int) string {
+	return fmt.Sprintf("nvme:%02x:%d:%08x:%08x:%d", cmd.opcode, cmd.nsid, cmd.cdw10, cmd.cdw11, dataLen)
+}
+
+// nsidBytes : store a namespace id as a pass-through response
+func nsidBytes(nsid uint32) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, nsid)
+	return buf
+}
+
+// errorText : an error as recorded in a capture
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// recordedError : an error read back from a capture
+func recordedError(text string) error {
+	if text == "" {
+		return nil
+	}
+	return errors.New(text)
+}
+
+// recordingBackend : wrap a backend and keep its answers for a capture bundle
+type recordingBackend struct {
+	diskBackend
+	inventory *recordedInventory
+}
+
+func newRecordingBackend(b diskBackend) *recordingBackend {
+	inventory := &recordedInventory{
+		Backend:        b.name(),
+		AvailableError: errorText(b.available()),
+		Devices:        make(map[string]*recordedDisk),
+	}
+	return &recordingBackend{diskBackend: b, inventory: inventory}
+}
+
+// device : the record for a disk, created on first use
+func (r *recordingBackend) device(devPath string) *recordedDisk {
+	d, ok := r.inventory.Devices[devPath]
+	if !ok {
+		d = &recordedDisk{Errors: make(map[string]string)}
+		r.inventory.Devices[devPath] = d
+	}
+	return d
+}
+
+// note : remember the error returned by one backend call
+func (r *recordingBackend) note(devPath string, call string, err error) {
+	if err != nil {
+		r.device(devPath).Errors[call] = err.Error()
+	}
+}
+
+func (r *recordingBackend) list() ([]string, error) {
+	disks, err := r.diskBackend.list()
+	r.inventory.Disks = disks
+	r.inventory.ListError = errorText(err)
+	return disks, err
+}
+
+func (r *recordingBackend) serialNum(devPath string) (string, error) {
+	v, err := r.diskBackend.serialNum(devPath)
+	r.device(devPath).Serial = v
+	r.note(devPath, "serial", err)
+	return v, err
+}
+
+func (r *recordingBackend) vpd83(devPath string) (string, error) {
+	v, err := r.diskBackend.vpd83(devPath)
+	r.device(devPath).Vpd83 = v
+	r.note(devPath, "vpd83", err)
+	return v, err
+}
+
+func (r *recordingBackend) healthStatus(devPath string) (lsm.DiskHealthStatus, error) {
+	v, err := r.diskBackend.healthStatus(devPath)
+	r.device(devPath).Health = v
+	r.note(devPath, "health", err)
+	return v, err
+}
+
+func (r *recordingBackend) rpm(devPath string) (int32, error) {
+	v, err := r.diskBackend.rpm(devPath)
+	r.device(devPath).Rpm = v
+	r.note(devPath, "rpm", err)
+	return v, err
+}
+
+func (r *recordingBackend) linkType(devPath string) (lsm.DiskLinkType, error) {
+	v, err := r.diskBackend.linkType(devPath)
+	r.device(devPath).LinkType = v
+	r.note(devPath, "link_type", err)
+	return v, err
+}
+
+func (r *recordingBackend) linkSpeed(devPath string) (uint32, error) {
+	v, err := r.diskBackend.linkSpeed(devPath)
+	r.device(devPath).LinkSpeed = v
+	r.note(devPath, "link_speed", err)
+	return v, err
+}
+
+func (r *recordingBackend) ledStatus(devPath string) (lsm.DiskLedStatusBitField, error) {
+	v, err := r.diskBackend.ledStatus(devPath)
+	r.device(devPath).LedStatus = v
+	r.note(devPath, "led_status", err)
+	return v, err
+}
+
+// replayBackend : answer backend calls from a capture bundle
+type replayBackend struct{}
+
+func init() {
+	backendFactories["replay"] = func() diskBackend { return replayBackend{} }
+}
+
+func (replayBackend) name() string {
+	return "replay"
+}
+
+func (replayBackend) available() error {
+	if replayInventory == nil {
+		return errors.New("no capture bundle loaded, use -root on an extracted capture")
+	}
+	return recordedError(replayInventory.AvailableError)
+}
+
+func (replayBackend) list() ([]string, error) {
+	if replayInventory == nil {
+		return nil, errors.New("no capture bundle loaded")
+	}
+	return replayInventory.Disks, recordedError(replayInventory.ListError)
+}
+
+// recorded : the captured answers for a disk
+func (replayBackend) recorded(devPath string) *recordedDisk {
+	if replayInventory != nil {
+		if d, ok := replayInventory.Devices[devPath]; ok {
+			return d
+		}
+	}
+	return &recordedDisk{Errors: map[string]string{"all": devPath + " was not captured"}}
+}
+
+// recordedErr : the error a captured call returned
+func (b replayBackend) recordedErr(devPath string, call string) error {
+	d := b.recorded(devPath)
+	if text, ok := d.Errors["all"]; ok {
+		return errors.New(text)
+	}
+	return recordedError(d.Errors[call])
+}
+
+func (b replayBackend) serialNum(devPath string) (string, error) {
+	return b.recorded(devPath).Serial, b.recordedErr(devPath, "serial")
+}
+
+func (b replayBackend) vpd83(devPath string) (string, error) {
+	return b.recorded(devPath).Vpd83, b.recordedErr(devPath, "vpd83")
+}
+
+func (b replayBackend) healthStatus(devPath string) (lsm.DiskHealthStatus, error) {
+	return b.recorded(devPath).Health, b.recordedErr(devPath, "health")
+}
+
+func (b replayBackend) rpm(devPath string) (int32, error) {
+	return b.recorded(devPath).Rpm, b.recordedErr(devPath, "rpm")
+}
+
+func (b replayBackend) linkType(devPath string) (lsm.DiskLinkType, error) {
+	return b.recorded(devPath).LinkType, b.recordedErr(devPath, "link_type")
+}
+
+func (b replayBackend) linkSpeed(devPath string) (uint32, error) {
+	return b.recorded(devPath).LinkSpeed, b.recordedErr(devPath, "link_speed")
+}
+
+func (b replayBackend) ledStatus(devPath string) (lsm.DiskLedStatusBitField, error) {
+	return b.recorded(devPath).LedStatus, b.recordedErr(devPath, "led_status")
+}
+
+func (replayBackend) faultLedOn(devPath string) error {
+	return errors.New("a captured inventory is read only")
+}
+
+func (replayBackend) faultLedOff(devPath string) error {
+	return errors.New("a captured inventory is read only")
+}
+
+func (replayBackend) identLedOn(devPath string) error {
+	return errors.New("a captured inventory is read only")
+}
+
+func (replayBackend) identLedOff(devPath string) error {
+	return errors.New("a captured inventory is read only")
+}
+
+// loadCapture : pick up the recorded backend answers and pass-through responses of an extracted bundle
+func loadCapture(dir string) error {
+	if content, err := ioutil.ReadFile(filepath.Join(dir, bundleBackend)); err == nil {
+		replayInventory = &recordedInventory{}
+		if err = json.Unmarshal(content, replayInventory); err != nil {
+			return errors.New("Unable to read " + bundleBackend + ": " + err.Error())
+		}
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dir, bundlePassThrough)); err == nil {
+		if err = json.Unmarshal(content, &passThroughLog); err != nil {
+			return errors.New("Unable to read " + bundlePassThrough + ": " + err.Error())
+		}
+		replayingPassThrough = true
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+// Alternate filesystem roots so the collectors can read a captured tree (a
+// must-gather, sosreport or extracted capture bundle) instead of the live
+// host. Code keeps using live paths such as /sys/class/block/sda and maps
+// them with hostPath just before touching the filesystem.
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var sysfsRoot = "/sys"
+var devRoot = "/dev"
+var udevRoot = "/run/udev"
+var procRoot = "/proc"
+
+var rootFlag string
+var sysfsRootFlag string
+var devRootFlag string
+var udevRootFlag string
+
+// addRootFlags : register the -root and per tree root flags on a flag set
+func addRootFlags(fs *flag.FlagSet) {
+	fs.StringVar(&rootFlag, "root", "", "read sys, dev, run/udev and proc from this directory instead of the live host")
+	fs.StringVar(&sysfsRootFlag, "sysfs-root", "", "read sysfs from this directory instead of /sys")
+	fs.StringVar(&devRootFlag, "dev-root", "", "open device nodes from this directory instead of /dev")
+	fs.StringVar(&udevRootFlag, "udev-root", "", "read the udev database from this directory instead of /run/udev")
+}
+
+// applyRootFlags : set the filesystem roots from the parsed flags; per tree flags override -root
+func applyRootFlags() error {
+	if rootFlag != "" {
+		if info, err := os.Stat(rootFlag); err != nil || !info.IsDir() {
+			return errors.New("Root " + rootFlag + " is not a directory")
+		}
+		sysfsRoot = filepath.Join(rootFlag, "sys")
+		devRoot = filepath.Join(rootFlag, "dev")
+		udevRoot = filepath.Join(rootFlag, "run/udev")
+		procRoot = filepath.Join(rootFlag, "proc")
+		if err := loadCapture(rootFlag); err != nil {
+			return err
+		}
+	}
+	if sysfsRootFlag != "" {
+		sysfsRoot = sysfsRootFlag
+	}
+	if devRootFlag != "" {
+		devRoot = devRootFlag
+	}
+	if udevRootFlag != "" {
+		udevRoot = udevRootFlag
+	}
+	return nil
+}
+
+// liveHost : true when every root points at the running system
+func liveHost() bool {
+	return sysfsRoot == "/sys" && devRoot == "/dev" && udevRoot == "/run/udev" && procRoot == "/proc"
+}
+
+// hostPath : map a live host path onto the configured roots
+func hostPath(path string) string {
+	roots := []struct {
+		live string
+		root string
+	}{
+		{"/sys", sysfsRoot},
+		{"/dev", devRoot},
+		{"/run/udev", udevRoot},
+		{"/proc", procRoot},
+	}
+	for _, r := range roots {
+		if path == r.live || strings.HasPrefix(path, r.live+"/") {
+			return r.root + strings.TrimPrefix(path, r.live)
+		}
+	}
+	return path
+}
+
+// udevProperties : the E: properties udev recorded for a block device
+func udevProperties(devPath string) map[string]string {
+	props := make(map[string]string)
+
+	devName, _ := extractDev(devPath)
+	majMin, err := readFile("/sys/class/block/" + devName + "/dev")
+	if err != nil {
+		return props
+	}
+	content, err := readFile("/run/udev/data/b" + majMin)
+	if err != nil {
+		return props
+	}
+	for _, line := range strings.Split(content, "\n") {
+		if !strings.HasPrefix(line, "E:") {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(line, "E:"), "=", 2)
+		if len(kv) == 2 {
+			props[kv[0]] = kv[1]
+		}
+	}
+	return props
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a SCSI disk with VPD pages 0x80 and 0x83 (one NAA designator)
+var scsiFixture = map[string]string{
+	"sys/class/block/sda/device/vpd_pg80": "\x00\x80\x00\x08ZC1234AB",
+	"sys/class/block/sda/device/vpd_pg83": "\x00\x83\x00\x0c\x01\x03\x00\x08\x50\x00\xc5\x00\xa1\xb2\xc3\xd4",
+}
+
+// an NVMe namespace, serial on the controller and NGUID on the block device
+var nvmeFixture = map[string]string{
+	"sys/class/block/nvme0n1/device/serial": "S4EWNX0R123456\n",
+	"sys/class/block/nvme0n1/nguid":         "e8238fa6-bf53-0001-001b-448b45a3c4e2\n",
+}
+
+// a virtio disk with its serial on the block device and the WWN only in the udev database
+var virtioFixture = map[string]string{
+	"sys/class/block/vdb/serial": "2390449912882\n",
+	"sys/class/block/vdb/dev":    "252:16\n",
+	"run/udev/data/b252:16":      "E:ID_SERIAL=2390449912882\nE:ID_WWN=0x5002538e40a1b2c3\n",
+	"sys/class/block/vdc/dev":    "252:32\n",
+}
+
+var sysfsIdentityTests = []struct {
+	fixture map[string]string
+	devPath string
+	serial  string
+	vpd83   string
+}{
+	{scsiFixture, "/dev/sda", "ZC1234AB", "5000c500a1b2c3d4"},
+	{nvmeFixture, "/dev/nvme0n1", "S4EWNX0R123456", "e8238fa6bf530001001b448b45a3c4e2"},
+	{virtioFixture, "/dev/vdb", "2390449912882", "5002538e40a1b2c3"},
+	{virtioFixture, "/dev/vdc", "", ""},
+}
+
+// writeFixture : create a fixture tree under dir
+func writeFixture(t *testing.T, dir string, fixture map[string]string) {
+	for name, content := range fixture {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSysfsIdentity(t *testing.T) {
+	defer func(sys, udev string) { sysfsRoot, udevRoot = sys, udev }(sysfsRoot, udevRoot)
+
+	for _, test := range sysfsIdentityTests {
+		dir, err := ioutil.TempDir("", "localdisk-fixture")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		writeFixture(t, dir, test.fixture)
+		sysfsRoot, udevRoot = filepath.Join(dir, "sys"), filepath.Join(dir, "run/udev")
+
+		serial, err := sysfsBackend{}.serialNum(test.devPath)
+		if serial != test.serial || (err == nil) != (test.serial != "") {
+			t.Errorf("serialNum(%s) = %q, %v, want %q", test.devPath, serial, err, test.serial)
+		}
+		vpd83, err := sysfsBackend{}.vpd83(test.devPath)
+		if vpd83 != test.vpd83 || (err == nil) != (test.vpd83 != "") {
+			t.Errorf("vpd83(%s) = %q, %v, want %q", test.devPath, vpd83, err, test.vpd83)
+		}
+	}
+}
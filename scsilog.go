@@ -0,0 +1,303 @@
+package main
+
+// SCSI LOG SENSE collection for SAS (and other native SCSI) disks. Each log
+// page is a 4 byte header followed by a list of parameters, every one of
+// which has a 2 byte code, a control byte and a length prefixed value.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	scsiOpLogSense         = 0x4d
+	logPageSupported       = 0x00
+	logPageWriteErrors     = 0x02
+	logPageReadErrors      = 0x03
+	logPageVerifyErrors    = 0x05
+	logPageNonMedium       = 0x06
+	logPageTemperature     = 0x0d
+	logPageStartStop       = 0x0e
+	logPageSelfTest        = 0x10
+	logPageSolidStateMedia = 0x11
+	logPageInfoExceptions  = 0x2f
+	logPageCumulative      = 0x40
+)
+
+var healthLogPages = map[uint8]bool{
+	logPageWriteErrors:     true,
+	logPageReadErrors:      true,
+	logPageVerifyErrors:    true,
+	logPageNonMedium:       true,
+	logPageTemperature:     true,
+	logPageStartStop:       true,
+	logPageSelfTest:        true,
+	logPageSolidStateMedia: true,
+	logPageInfoExceptions:  true,
+}
+
+var selfTestCodeText = map[uint8]string{
+	0: "Default",
+	1: "Background short",
+	2: "Background extended",
+	4: "Aborted background",
+	5: "Foreground short",
+	6: "Foreground extended",
+}
+
+var selfTestResultText = map[uint8]string{
+	0:   "Completed",
+	1:   "Aborted by command",
+	2:   "Aborted",
+	3:   "Unknown error",
+	4:   "Failed in unknown segment",
+	5:   "Failed in first segment",
+	6:   "Failed in second segment",
+	7:   "Failed in segment",
+	0xf: "In progress",
+}
+
+type scsiTemperaturePage struct {
+	Current   int `json:"current_celsius"`
+	Reference int `json:"reference_celsius"`
+}
+
+type scsiStartStopPage struct {
+	ManufactureDate        string `json:"manufacture_date,omitempty"`
+	AccountingDate         string `json:"accounting_date,omitempty"`
+	SpecifiedStartStops    uint64 `json:"specified_start_stop_cycles"`
+	AccumulatedStartStops  uint64 `json:"accumulated_start_stop_cycles"`
+	SpecifiedLoadUnloads   uint64 `json:"specified_load_unload_cycles"`
+	AccumulatedLoadUnloads uint64 `json:"accumulated_load_unload_cycles"`
+}
+
+type scsiErrorCounters struct {
+	CorrectedNoDelay  uint64 `json:"corrected_without_delay"`
+	CorrectedDelayed  uint64 `json:"corrected_with_delay"`
+	Retries           uint64 `json:"total_rewrites_rereads"`
+	TotalCorrected    uint64 `json:"total_corrected"`
+	CorrectionInvoked uint64 `json:"correction_algorithm_invocations"`
+	BytesProcessed    uint64 `json:"bytes_processed"`
+	Uncorrected       uint64 `json:"total_uncorrected"`
+}
+
+type scsiSelfTest struct {
+	Number          uint16 `json:"number"`
+	Type            string `json:"type"`
+	Result          string `json:"result"`
+	Segment         uint8  `json:"segment"`
+	PowerOnHours    uint16 `json:"power_on_hours"`
+	FirstFailureLBA uint64 `json:"first_failure_lba"`
+	SenseKey        uint8  `json:"sense_key"`
+	Asc             uint8  `json:"asc"`
+	Ascq            uint8  `json:"ascq"`
+}
+
+type scsiInfoExceptions struct {
+	Asc               uint8 `json:"asc"`
+	Ascq              uint8 `json:"ascq"`
+	RecentTemperature uint8 `json:"recent_temperature_celsius"`
+	FailurePredicted  bool  `json:"failure_predicted"`
+}
+
+type scsiLogPages struct {
+	Temperature     *scsiTemperaturePage `json:"temperature,omitempty"`
+	StartStop       *scsiStartStopPage   `json:"start_stop,omitempty"`
+	WriteErrors     *scsiErrorCounters   `json:"write_errors,omitempty"`
+	ReadErrors      *scsiErrorCounters   `json:"read_errors,omitempty"`
+	VerifyErrors    *scsiErrorCounters   `json:"verify_errors,omitempty"`
+	NonMediumErrors *uint64              `json:"non_medium_errors,omitempty"`
+	SelfTests       []scsiSelfTest       `json:"self_tests,omitempty"`
+	PercentUsed     *uint8               `json:"percentage_used_endurance,omitempty"`
+	InfoExceptions  *scsiInfoExceptions  `json:"informational_exceptions,omitempty"`
+}
+
+// beUint : decode a big endian counter of up to 8 bytes
+func beUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// logSense : read a complete log page, sizing the transfer from its header
+func logSense(f *os.File, page uint8) ([]byte, error) {
+	cdb := []byte{scsiOpLogSense, 0, logPageCumulative | page, 0, 0, 0, 0, 0, 4, 0}
+	header := make([]byte, 4)
+	if err := sgioCommand(f, cdb, header, sgDxferFromDev); err != nil {
+		return nil, err
+	}
+	length := 4 + int(binary.BigEndian.Uint16(header[2:4]))
+	if header[0]&0x3f != page {
+		return nil, fmt.Errorf("log page 0x%02x: device returned page 0x%02x", page, header[0]&0x3f)
+	}
+
+	buf := make([]byte, length)
+	binary.BigEndian.PutUint16(cdb[7:9], uint16(length))
+	if err := sgioCommand(f, cdb, buf, sgDxferFromDev); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// logParams : split a log page into its parameter values keyed by parameter code
+func logParams(page []byte) map[uint16][]byte {
+	params := make(map[uint16][]byte)
+
+	end := 4 + int(binary.BigEndian.Uint16(page[2:4]))
+	if end > len(page) {
+		end = len(page)
+	}
+	for off := 4; off+4 <= end; {
+		code := binary.BigEndian.Uint16(page[off : off+2])
+		next := off + 4 + int(page[off+3])
+		if next > end {
+			break
+		}
+		params[code] = page[off+4 : next]
+		off = next
+	}
+	return params
+}
+
+// decodeErrorCounters : decode a write/read/verify error counter page
+func decodeErrorCounters(page []byte) *scsiErrorCounters {
+	p := logParams(page)
+	return &scsiErrorCounters{
+		CorrectedNoDelay:  beUint(p[0x0000]),
+		CorrectedDelayed:  beUint(p[0x0001]),
+		Retries:           beUint(p[0x0002]),
+		TotalCorrected:    beUint(p[0x0003]),
+		CorrectionInvoked: beUint(p[0x0004]),
+		BytesProcessed:    beUint(p[0x0005]),
+		Uncorrected:       beUint(p[0x0006]),
+	}
+}
+
+// decodeTemperature : decode the temperature page
+func decodeTemperature(page []byte) *scsiTemperaturePage {
+	p := logParams(page)
+	temp := &scsiTemperaturePage{Current: -1, Reference: -1}
+	// 0xff means the sensor value is not available
+	if v := p[0x0000]; len(v) >= 2 && v[1] != 0xff {
+		temp.Current = int(v[1])
+	}
+	if v := p[0x0001]; len(v) >= 2 && v[1] != 0xff {
+		temp.Reference = int(v[1])
+	}
+	return temp
+}
+
+// logDate : format a YYYYWW ascii date parameter
+func logDate(v []byte) string {
+	if len(v) < 6 || strings.TrimSpace(string(v[:6])) == "" {
+		return ""
+	}
+	return string(v[0:4]) + " week " + string(v[4:6])
+}
+
+// decodeStartStop : decode the start-stop cycle counter page
+func decodeStartStop(page []byte) *scsiStartStopPage {
+	p := logParams(page)
+	return &scsiStartStopPage{
+		ManufactureDate:        logDate(p[0x0001]),
+		AccountingDate:         logDate(p[0x0002]),
+		SpecifiedStartStops:    beUint(p[0x0003]),
+		AccumulatedStartStops:  beUint(p[0x0004]),
+		SpecifiedLoadUnloads:   beUint(p[0x0005]),
+		AccumulatedLoadUnloads: beUint(p[0x0006]),
+	}
+}
+
+// decodeSelfTests : decode the self-test results page, skipping unused slots
+func decodeSelfTests(page []byte) []scsiSelfTest {
+	var tests []scsiSelfTest
+
+	params := logParams(page)
+	for code := uint16(1); code <= 20; code++ {
+		v, ok := params[code]
+		if !ok || len(v) < 16 {
+			continue
+		}
+		poh := binary.BigEndian.Uint16(v[2:4])
+		if v[0] == 0 && poh == 0 {
+			continue
+		}
+		tests = append(tests, scsiSelfTest{
+			Number:          code,
+			Type:            selfTestCodeText[v[0]>>5],
+			Result:          selfTestResultText[v[0]&0x0f],
+			Segment:         v[1],
+			PowerOnHours:    poh,
+			FirstFailureLBA: binary.BigEndian.Uint64(v[4:12]),
+			SenseKey:        v[12] & 0x0f,
+			Asc:             v[13],
+			Ascq:            v[14],
+		})
+	}
+	return tests
+}
+
+// decodeInfoExceptions : decode the informational exceptions page
+func decodeInfoExceptions(page []byte) *scsiInfoExceptions {
+	v := logParams(page)[0x0000]
+	if len(v) < 3 {
+		return nil
+	}
+	// ASC 0x5d is "failure prediction threshold exceeded"
+	return &scsiInfoExceptions{Asc: v[0], Ascq: v[1], RecentTemperature: v[2], FailurePredicted: v[0] == 0x5d}
+}
+
+// scsiLogPagesGet : read the health related log pages a SCSI disk supports
+func scsiLogPagesGet(devPath string) (*scsiLogPages, error) {
+	f, err := openPassThrough(devPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	supported, err := logSense(f, logPageSupported)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := &scsiLogPages{}
+	for _, code := range supported[4:] {
+		code &= 0x3f
+		if !healthLogPages[code] {
+			continue
+		}
+		page, err := logSense(f, code)
+		if err != nil {
+			continue
+		}
+		switch code {
+		case logPageTemperature:
+			pages.Temperature = decodeTemperature(page)
+		case logPageStartStop:
+			pages.StartStop = decodeStartStop(page)
+		case logPageWriteErrors:
+			pages.WriteErrors = decodeErrorCounters(page)
+		case logPageReadErrors:
+			pages.ReadErrors = decodeErrorCounters(page)
+		case logPageVerifyErrors:
+			pages.VerifyErrors = decodeErrorCounters(page)
+		case logPageNonMedium:
+			count := beUint(logParams(page)[0x0000])
+			pages.NonMediumErrors = &count
+		case logPageSelfTest:
+			pages.SelfTests = decodeSelfTests(page)
+		case logPageSolidStateMedia:
+			if v := logParams(page)[0x0001]; len(v) >= 4 {
+				used := v[3]
+				pages.PercentUsed = &used
+			}
+		case logPageInfoExceptions:
+			pages.InfoExceptions = decodeInfoExceptions(page)
+		}
+	}
+	return pages, nil
+}
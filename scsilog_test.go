@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type logParam struct {
+	code  uint16
+	value []byte
+}
+
+// logPage : a LOG SENSE page holding the given parameters in order
+func logPage(page uint8, params ...logParam) []byte {
+	buf := []byte{page, 0, 0, 0}
+	for _, p := range params {
+		header := []byte{0, 0, 0x03, byte(len(p.value))}
+		binary.BigEndian.PutUint16(header[0:2], p.code)
+		buf = append(append(buf, header...), p.value...)
+	}
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)-4))
+	return buf
+}
+
+// selfTestParam : a 16 byte self-test results log parameter
+func selfTestParam(code uint16, codeResult byte, segment byte, poh uint16, lba uint64, sense byte, asc byte, ascq byte) logParam {
+	v := make([]byte, 16)
+	v[0], v[1] = codeResult, segment
+	binary.BigEndian.PutUint16(v[2:4], poh)
+	binary.BigEndian.PutUint64(v[4:12], lba)
+	v[12], v[13], v[14] = sense, asc, ascq
+	return logParam{code, v}
+}
+
+func TestLogParams(t *testing.T) {
+	page := logPage(logPageReadErrors, logParam{0x0000, []byte{1}}, logParam{0x8001, []byte{2, 3}})
+	want := map[uint16][]byte{0x0000: {1}, 0x8001: {2, 3}}
+	if got := logParams(page); !reflect.DeepEqual(got, want) {
+		t.Errorf("logParams = %v, want %v", got, want)
+	}
+
+	// a parameter running past the page length is dropped, the ones before it kept
+	cut := logPage(logPageReadErrors, logParam{0x0000, []byte{1}}, logParam{0x0001, []byte{2, 3, 4, 5}})
+	want = map[uint16][]byte{0x0000: {1}}
+	if got := logParams(cut[:len(cut)-2]); !reflect.DeepEqual(got, want) {
+		t.Errorf("logParams of a truncated page = %v, want %v", got, want)
+	}
+}
+
+func TestDecodeErrorCounters(t *testing.T) {
+	page := logPage(logPageReadErrors,
+		logParam{0x0000, []byte{0x00, 0x00, 0x12, 0x34}},
+		logParam{0x0001, []byte{0x05}},
+		logParam{0x0003, []byte{0x00, 0x00, 0x12, 0x39}},
+		logParam{0x0004, []byte{0x00, 0x10}},
+		logParam{0x0005, []byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		logParam{0x0006, []byte{0x02}},
+	)
+	want := scsiErrorCounters{CorrectedNoDelay: 0x1234, CorrectedDelayed: 5, TotalCorrected: 0x1239,
+		CorrectionInvoked: 16, BytesProcessed: 1 << 40, Uncorrected: 2}
+	if got := decodeErrorCounters(page); *got != want {
+		t.Errorf("decodeErrorCounters = %+v, want %+v", *got, want)
+	}
+}
+
+func TestDecodeTemperature(t *testing.T) {
+	tests := []struct {
+		name string
+		page []byte
+		want scsiTemperaturePage
+	}{
+		{"current and reference", logPage(logPageTemperature, logParam{0x0000, []byte{0, 38}}, logParam{0x0001, []byte{0, 65}}),
+			scsiTemperaturePage{Current: 38, Reference: 65}},
+		{"sensor not available", logPage(logPageTemperature, logParam{0x0000, []byte{0, 0xff}}, logParam{0x0001, []byte{0, 60}}),
+			scsiTemperaturePage{Current: -1, Reference: 60}},
+		{"no parameters", logPage(logPageTemperature),
+			scsiTemperaturePage{Current: -1, Reference: -1}},
+	}
+	for _, test := range tests {
+		if got := decodeTemperature(test.page); *got != test.want {
+			t.Errorf("%s: decodeTemperature = %+v, want %+v", test.name, *got, test.want)
+		}
+	}
+}
+
+func TestDecodeStartStop(t *testing.T) {
+	page := logPage(logPageStartStop,
+		logParam{0x0001, []byte("201934")},
+		logParam{0x0002, []byte("      ")},
+		logParam{0x0003, []byte{0x00, 0x00, 0xc3, 0x50}},
+		logParam{0x0004, []byte{0x00, 0x00, 0x00, 0x2a}},
+		logParam{0x0005, []byte{0x00, 0x09, 0x27, 0xc0}},
+		logParam{0x0006, []byte{0x00, 0x00, 0x01, 0x2c}},
+	)
+	want := scsiStartStopPage{ManufactureDate: "2019 week 34", SpecifiedStartStops: 50000, AccumulatedStartStops: 42,
+		SpecifiedLoadUnloads: 600000, AccumulatedLoadUnloads: 300}
+	if got := decodeStartStop(page); *got != want {
+		t.Errorf("decodeStartStop = %+v, want %+v", *got, want)
+	}
+}
+
+func TestDecodeSelfTests(t *testing.T) {
+	page := logPage(logPageSelfTest,
+		selfTestParam(1, 0xa7, 2, 12345, 0x1d1c5970, 0x03, 0x11, 0x00),
+		selfTestParam(2, 0x20, 0, 12001, ^uint64(0), 0, 0, 0),
+		// an unused slot
+		selfTestParam(3, 0x00, 0, 0, 0, 0, 0, 0),
+		// too short to decode
+		logParam{4, []byte{0x20, 0, 0, 1}},
+	)
+	want := []scsiSelfTest{
+		{Number: 1, Type: "Foreground short", Result: "Failed in segment", Segment: 2, PowerOnHours: 12345,
+			FirstFailureLBA: 0x1d1c5970, SenseKey: 3, Asc: 0x11},
+		{Number: 2, Type: "Background short", Result: "Completed", PowerOnHours: 12001, FirstFailureLBA: ^uint64(0)},
+	}
+	if got := decodeSelfTests(page); !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeSelfTests =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDecodeInfoExceptions(t *testing.T) {
+	tests := []struct {
+		name string
+		page []byte
+		want *scsiInfoExceptions
+	}{
+		{"no exception", logPage(logPageInfoExceptions, logParam{0x0000, []byte{0, 0, 36}}),
+			&scsiInfoExceptions{RecentTemperature: 36}},
+		{"failure predicted", logPage(logPageInfoExceptions, logParam{0x0000, []byte{0x5d, 0x10, 41, 60}}),
+			&scsiInfoExceptions{Asc: 0x5d, Ascq: 0x10, RecentTemperature: 41, FailurePredicted: true}},
+		{"parameter too short", logPage(logPageInfoExceptions, logParam{0x0000, []byte{0, 0}}), nil},
+	}
+	for _, test := range tests {
+		if got := decodeInfoExceptions(test.page); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: decodeInfoExceptions = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
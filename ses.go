@@ -0,0 +1,436 @@
+package main
+
+// IBPI LED patterns (SFF-8489) through SES. Backplanes with an SES enclosure
+// processor show rebuild, hot spare, predicted failure, in a critical array
+// and in a failed array from request bits of the disk's Array Device Slot
+// element (SES-3, 7.3.3). The element is found through the Additional Element
+// Status page (0x0a) by the disk's SAS address, or by its descriptor text from
+// the Element Descriptor page (0x07), which is also how the kernel names the
+// slot directories under /sys/class/enclosure.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	scsiOpReceiveDiagnostic = 0x1c
+	scsiOpSendDiagnostic    = 0x1d
+
+	sesPageConfiguration     = 0x01
+	sesPageStatus            = 0x02
+	sesPageElementDescriptor = 0x07
+	sesPageAdditionalStatus  = 0x0a
+
+	sesElementDeviceSlot      = 0x01
+	sesElementArrayDeviceSlot = 0x17
+
+	sesProtocolSAS = 0x6
+
+	ledMechanismSes = "ses"
+)
+
+// the control/status byte and bit of each pattern in an array device slot element
+var sesPatterns = []struct {
+	name string
+	byte int
+	bit  byte
+	// device slot elements only have PRDFAIL
+	deviceSlot bool
+}{
+	{"rebuild", 1, 0x02, false},
+	{"hotspare", 1, 0x20, false},
+	{"pfa", 0, 0x40, true},
+	{"ica", 1, 0x08, false},
+	{"ifa", 1, 0x04, false},
+}
+
+// status bits that mean the same in the control element, per byte
+var sesControlMask = [4]byte{0x40, 0xff, 0x4e, 0x30}
+
+const sesSelect = 0x80
+
+type sesElement struct {
+	elementType byte
+	// offset of the element in the status and control pages
+	offset int
+}
+
+// receiveDiagnostic : read a complete SES diagnostic page, sizing the transfer from its header
+func receiveDiagnostic(f *os.File, page uint8) ([]byte, error) {
+	cdb := []byte{scsiOpReceiveDiagnostic, 0x01, page, 0, 4, 0}
+	header := make([]byte, 4)
+	if err := sgioCommand(f, cdb, header, sgDxferFromDev); err != nil {
+		return nil, err
+	}
+	if header[0] != page {
+		return nil, fmt.Errorf("diagnostic page 0x%02x: device returned page 0x%02x", page, header[0])
+	}
+	length := 4 + int(binary.BigEndian.Uint16(header[2:4]))
+
+	buf := make([]byte, length)
+	binary.BigEndian.PutUint16(cdb[3:5], uint16(length))
+	if err := sgioCommand(f, cdb, buf, sgDxferFromDev); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// sendDiagnostic : send an SES control page
+func sendDiagnostic(f *os.File, page []byte) error {
+	cdb := []byte{scsiOpSendDiagnostic, 0x10, 0, byte(len(page) >> 8), byte(len(page)), 0}
+	return sgioIssue(f, cdb, page, sgDxferToDev)
+}
+
+// sesTypes : the element type and count of every type descriptor header in the configuration page
+func sesTypes(config []byte) ([][2]int, error) {
+	if len(config) < 8 {
+		return nil, errors.New("short SES configuration page")
+	}
+	offset := 8
+	headers := 0
+	for i := 0; i <= int(config[1]); i++ {
+		if offset+4 > len(config) {
+			return nil, errors.New("truncated SES enclosure descriptor")
+		}
+		headers += int(config[offset+2])
+		offset += 4 + int(config[offset+3])
+	}
+	var types [][2]int
+	for i := 0; i < headers; i++ {
+		if offset+4 > len(config) {
+			return nil, errors.New("truncated SES type descriptor header")
+		}
+		types = append(types, [2]int{int(config[offset]), int(config[offset+1])})
+		offset += 4
+	}
+	return types, nil
+}
+
+// sesSlotElements : the device slot elements by element index, counted without and with the
+// overall elements, and in status page order
+func sesSlotElements(types [][2]int) (map[int]sesElement, map[int]sesElement, []sesElement) {
+	excluding := make(map[int]sesElement)
+	including := make(map[int]sesElement)
+	var ordered []sesElement
+	offset := 8
+	index := 0
+	for i, t := range types {
+		offset += 4 // overall element
+		for j := 0; j < t[1]; j++ {
+			if t[0] == sesElementDeviceSlot || t[0] == sesElementArrayDeviceSlot {
+				element := sesElement{elementType: byte(t[0]), offset: offset}
+				excluding[index] = element
+				including[index+i+1] = element
+				ordered = append(ordered, element)
+			}
+			offset += 4
+			index++
+		}
+	}
+	return excluding, including, ordered
+}
+
+// sasAddressElement : the slot element whose attached device has a given SAS address
+func sasAddressElement(aes []byte, types [][2]int, sasAddress uint64) (sesElement, bool) {
+	excluding, including, ordered := sesSlotElements(types)
+	sequence := 0
+	for offset := 8; offset+2 <= len(aes); {
+		desc := aes[offset:]
+		length := 2 + int(desc[1])
+		if length > len(desc) {
+			break
+		}
+		desc = desc[:length]
+		offset += length
+
+		eip := desc[0]&0x10 != 0
+		var element sesElement
+		var ok bool
+		info := desc[2:]
+		if eip {
+			if len(desc) < 4 {
+				continue
+			}
+			if desc[2]&0x03 == 1 {
+				element, ok = including[int(desc[3])]
+			} else {
+				element, ok = excluding[int(desc[3])]
+			}
+			info = desc[4:]
+		} else if sequence < len(ordered) {
+			element, ok = ordered[sequence], true
+			sequence++
+		}
+		if !ok || desc[0]&0x80 != 0 || desc[0]&0x0f != sesProtocolSAS || len(info) < 2 {
+			continue
+		}
+		phys := int(info[0])
+		// with EIP set there are 2 more bytes between the phy count and the phy descriptors
+		first := 2
+		if eip {
+			first = 4
+		}
+		for p := 0; p < phys; p++ {
+			phy := first + p*28
+			if phy+28 > len(info) {
+				break
+			}
+			if binary.BigEndian.Uint64(info[phy+12:phy+20]) == sasAddress {
+				return element, true
+			}
+		}
+	}
+	return sesElement{}, false
+}
+
+// descriptorElement : the slot element whose element descriptor text matches a name
+func descriptorElement(descriptors []byte, types [][2]int, name string) (sesElement, bool) {
+	_, _, ordered := sesSlotElements(types)
+	offset := 8
+	slot := 0
+	for _, t := range types {
+		for j := -1; j < t[1]; j++ {
+			if offset+4 > len(descriptors) {
+				return sesElement{}, false
+			}
+			length := int(binary.BigEndian.Uint16(descriptors[offset+2 : offset+4]))
+			text := strings.TrimRight(string(descriptors[offset+4:minInt(offset+4+length, len(descriptors))]), " \x00")
+			offset += 4 + length
+			// j == -1 is the overall element
+			if j < 0 || (t[0] != sesElementDeviceSlot && t[0] != sesElementArrayDeviceSlot) {
+				continue
+			}
+			if text != "" && text == name && slot < len(ordered) {
+				return ordered[slot], true
+			}
+			slot++
+		}
+	}
+	return sesElement{}, false
+}
+
+// minInt : the smaller of two ints
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// enclosureSgDevice : the SCSI generic node of the enclosure holding a disk, and the disk's slot name
+func enclosureSgDevice(devPath string) (string, string, error) {
+	slot, err := enclosureComponent(devPath)
+	if err != nil {
+		return "", "", err
+	}
+	realSlot, err := filepath.EvalSymlinks(slot)
+	if err != nil {
+		return "", "", err
+	}
+	sgs, _ := filepath.Glob(filepath.Join(filepath.Dir(realSlot), "device", "scsi_generic", "sg*"))
+	if len(sgs) == 0 {
+		return "", "", errors.New("no SCSI generic device found for the enclosure of " + devPath)
+	}
+	return "/dev/" + filepath.Base(sgs[0]), filepath.Base(realSlot), nil
+}
+
+// sesSlot : the open enclosure, its status page and the disk's element
+func sesSlot(devPath string, write bool) (*os.File, []byte, sesElement, error) {
+	sgPath, slotName, err := enclosureSgDevice(devPath)
+	if err != nil {
+		return nil, nil, sesElement{}, err
+	}
+	var f *os.File
+	if write {
+		// SEND DIAGNOSTIC needs a writable handle on sg devices
+		f, err = os.OpenFile(hostPath(sgPath), os.O_RDWR|syscall.O_NONBLOCK, 0)
+	} else {
+		f, err = openPassThrough(sgPath)
+	}
+	if err != nil {
+		return nil, nil, sesElement{}, err
+	}
+
+	fail := func(err error) (*os.File, []byte, sesElement, error) {
+		f.Close()
+		return nil, nil, sesElement{}, err
+	}
+	config, err := receiveDiagnostic(f, sesPageConfiguration)
+	if err != nil {
+		return fail(err)
+	}
+	types, err := sesTypes(config)
+	if err != nil {
+		return fail(err)
+	}
+	status, err := receiveDiagnostic(f, sesPageStatus)
+	if err != nil {
+		return fail(err)
+	}
+
+	element, found := sesElement{}, false
+	if address, err := readFile(blockSysfsPath(devPath) + "/device/sas_address"); err == nil {
+		var sasAddress uint64
+		if _, err := fmt.Sscanf(address, "0x%x", &sasAddress); err == nil {
+			if aes, err := receiveDiagnostic(f, sesPageAdditionalStatus); err == nil {
+				element, found = sasAddressElement(aes, types, sasAddress)
+			}
+		}
+	}
+	if !found {
+		if descriptors, err := receiveDiagnostic(f, sesPageElementDescriptor); err == nil {
+			element, found = descriptorElement(descriptors, types, slotName)
+		}
+	}
+	if !found {
+		return fail(errors.New("no SES slot element found for " + devPath + " in " + sgPath))
+	}
+	if element.offset+4 > len(status) {
+		return fail(errors.New("short SES status page from " + sgPath))
+	}
+	return f, status, element, nil
+}
+
+// sesSupportedPatterns : the patterns an element type can request
+func sesSupportedPatterns(element sesElement) []string {
+	supported := []string{}
+	for _, p := range sesPatterns {
+		if element.elementType == sesElementArrayDeviceSlot || p.deviceSlot {
+			supported = append(supported, p.name)
+		}
+	}
+	return supported
+}
+
+// sesPatternText : the patterns shown by a slot's status element, or none
+func sesPatternText(status []byte, element sesElement) string {
+	var shown []string
+	for _, p := range sesPatterns {
+		if (element.elementType == sesElementArrayDeviceSlot || p.deviceSlot) && status[element.offset+p.byte]&p.bit != 0 {
+			shown = append(shown, p.name)
+		}
+	}
+	if len(shown) == 0 {
+		return "none"
+	}
+	return strings.Join(shown, "+")
+}
+
+// sesPattern : the IBPI pattern of a disk's slot
+func sesPattern(devPath string) (string, error) {
+	f, status, element, err := sesSlot(devPath, false)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return sesPatternText(status, element), nil
+}
+
+// setSesPattern : request a single IBPI pattern, or none, on a disk's slot under the disk's lock,
+// recording it in the audit log
+func setSesPattern(devPath string, pattern string) ledChange {
+	if liveHost() {
+		unlock, err := lockDisk(devPath)
+		if err != nil {
+			return auditChange(ledChange{Device: devPath, Led: "pattern", Requested: pattern, Mechanism: ledMechanismSes, Supported: []string{}, Error: err.Error()})
+		}
+		defer unlock()
+	}
+	return auditChange(switchSesPattern(devPath, pattern))
+}
+
+// switchSesPattern : request a single IBPI pattern, or none, on a disk's slot
+func switchSesPattern(devPath string, pattern string) ledChange {
+	change := ledChange{Device: devPath, Led: "pattern", Requested: pattern, Mechanism: ledMechanismSes, Supported: []string{}}
+	if !liveHost() {
+		change.Error = "LEDs can only be changed on the live host"
+		return change
+	}
+
+	f, status, element, err := sesSlot(devPath, true)
+	if err != nil {
+		change.Error = err.Error()
+		return change
+	}
+	defer f.Close()
+	change.Before = sesPatternText(status, element)
+	change.Supported = sesSupportedPatterns(element)
+
+	if pattern != "none" {
+		supported := false
+		for _, name := range change.Supported {
+			supported = supported || name == pattern
+		}
+		if !supported {
+			change.After = change.Before
+			change.Error = "the enclosure's device slot elements can't show " + pattern
+			return change
+		}
+	}
+
+	// every other element stays as it is because its SELECT bit is clear
+	control := make([]byte, len(status))
+	copy(control[:8], status[:8])
+	control[1] = 0
+	for i := 0; i < 4; i++ {
+		control[element.offset+i] = status[element.offset+i] & sesControlMask[i]
+	}
+	if element.elementType == sesElementDeviceSlot {
+		control[element.offset+1] = 0
+	}
+	control[element.offset] |= sesSelect
+	for _, p := range sesPatterns {
+		control[element.offset+p.byte] &^= p.bit
+		if p.name == pattern {
+			control[element.offset+p.byte] |= p.bit
+		}
+	}
+	if err = sendDiagnostic(f, control); err != nil {
+		change.After = change.Before
+		change.Error = err.Error()
+		return change
+	}
+
+	status, err = receiveDiagnostic(f, sesPageStatus)
+	if err != nil {
+		change.After = ledUnknown
+		return change
+	}
+	change.After = sesPatternText(status, element)
+	change.Changed = change.Before != change.After
+	return change
+}
+
+// setPatternCommand : request an IBPI pattern on the named or selected disks, returning the exit code
+func setPatternCommand(pattern string, args []string) int {
+	known := pattern == "none"
+	var names []string
+	for _, p := range sesPatterns {
+		known = known || p.name == pattern
+		names = append(names, p.name)
+	}
+	if !known {
+		fmt.Println("Unknown LED pattern " + pattern + ", use " + strings.Join(names, ", ") + " or none")
+		return 1
+	}
+	devPaths, changes, err := ledTargets(args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	if len(devPaths) == 0 && len(changes) == 0 {
+		fmt.Println("No local disks match the selection")
+		return 0
+	}
+	for _, devPath := range devPaths {
+		changes = append(changes, setSesPattern(devPath, pattern))
+	}
+	return reportLedChanges(changes)
+}
@@ -0,0 +1,125 @@
+package main
+
+// SG_IO pass-through plumbing shared by the SCSI and ATA (SAT) collectors.
+// The layout of sgIoHdr mirrors struct sg_io_hdr from <scsi/sg.h>.
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	sgIO            = 0x2285
+	sgDxferNone     = -1
+	sgDxferToDev    = -2
+	sgDxferFromDev  = -3
+	sgTimeoutMillis = 20000
+	senseBufLen     = 32
+)
+
+type sgIoHdr struct {
+	interfaceID    int32
+	dxferDirection int32
+	cmdLen         uint8
+	mxSbLen        uint8
+	iovecCount     uint16
+	dxferLen       uint32
+	dxferp         unsafe.Pointer
+	cmdp           unsafe.Pointer
+	sbp            unsafe.Pointer
+	timeout        uint32
+	flags          uint32
+	packID         int32
+	usrPtr         unsafe.Pointer
+	status         uint8
+	maskedStatus   uint8
+	msgStatus      uint8
+	sbLenWr        uint8
+	hostStatus     uint16
+	driverStatus   uint16
+	resid          int32
+	duration       uint32
+	info           uint32
+}
+
+type senseError struct {
+	key  uint8
+	asc  uint8
+	ascq uint8
+}
+
+func (e *senseError) Error() string {
+	return fmt.Sprintf("check condition (sense key 0x%x, asc 0x%02x, ascq 0x%02x)", e.key, e.asc, e.ascq)
+}
+
+// decodeSense : extract the sense key and additional sense code from fixed or descriptor sense data
+func decodeSense(sense []byte) *senseError {
+	if len(sense) < 4 {
+		return &senseError{}
+	}
+	switch sense[0] & 0x7f {
+	case 0x72, 0x73:
+		return &senseError{key: sense[1] & 0x0f, asc: sense[2], ascq: sense[3]}
+	default:
+		if len(sense) < 14 {
+			return &senseError{key: sense[2] & 0x0f}
+		}
+		return &senseError{key: sense[2] & 0x0f, asc: sense[12], ascq: sense[13]}
+	}
+}
+
+// openPassThrough : open a device node for SG_IO/admin ioctls without waiting on media
+func openPassThrough(devPath string) (*os.File, error) {
+	return os.OpenFile(hostPath(devPath), os.O_RDONLY|syscall.O_NONBLOCK, 0)
+}
+
+// sgioCommand : issue a single SCSI command, or answer it from a capture bundle
+func sgioCommand(f *os.File, cdb []byte, data []byte, direction int32) error {
+	key := sgioKey(cdb, len(data))
+	if replayingPassThrough {
+		return replayPassThrough(f, key, data)
+	}
+	err := sgioIssue(f, cdb, data, direction)
+	recordPassThrough(f, key, data, err)
+	return err
+}
+
+// sgioIssue : issue a single SCSI command through the SG_IO ioctl
+func sgioIssue(f *os.File, cdb []byte, data []byte, direction int32) error {
+	var sense [senseBufLen]byte
+
+	hdr := sgIoHdr{
+		interfaceID:    'S',
+		dxferDirection: direction,
+		cmdLen:         uint8(len(cdb)),
+		mxSbLen:        senseBufLen,
+		dxferLen:       uint32(len(data)),
+		cmdp:           unsafe.Pointer(&cdb[0]),
+		sbp:            unsafe.Pointer(&sense[0]),
+		timeout:        sgTimeoutMillis,
+	}
+	if len(data) > 0 {
+		hdr.dxferp = unsafe.Pointer(&data[0])
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), sgIO, uintptr(unsafe.Pointer(&hdr)))
+	if errno != 0 {
+		return fmt.Errorf("SG_IO ioctl on %s failed: %v", f.Name(), errno)
+	}
+	if hdr.hostStatus != 0 {
+		return fmt.Errorf("SG_IO on %s: host status 0x%x", f.Name(), hdr.hostStatus)
+	}
+	if hdr.status != 0 || hdr.sbLenWr > 0 {
+		se := decodeSense(sense[:hdr.sbLenWr])
+		// recovered errors (e.g. "ATA pass through information available") are not failures
+		if se.key != 0 && se.key != 1 {
+			return se
+		}
+		if hdr.status != 0 && hdr.sbLenWr == 0 {
+			return fmt.Errorf("SG_IO on %s: scsi status 0x%x", f.Name(), hdr.status)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+// ATA SMART collection through the SCSI/ATA Translation (SAT) pass-through
+// commands. SMART READ DATA and READ THRESHOLDS each return a 512 byte
+// sector holding a table of 30 twelve byte attribute entries.
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+)
+
+const (
+	ataCmdSmart           = 0xb0
+	ataSmartReadData      = 0xd0
+	ataSmartReadThreshold = 0xd1
+	ataSmartLbaMid        = 0x4f
+	ataSmartLbaHigh       = 0xc2
+	ataProtoPioDataIn     = 4
+	ataSectorSize         = 512
+	smartAttrCount        = 30
+	smartAttrLen          = 12
+)
+
+var smartAttributeNames = map[uint8]string{
+	1:   "Raw_Read_Error_Rate",
+	3:   "Spin_Up_Time",
+	4:   "Start_Stop_Count",
+	5:   "Reallocated_Sector_Ct",
+	7:   "Seek_Error_Rate",
+	9:   "Power_On_Hours",
+	10:  "Spin_Retry_Count",
+	12:  "Power_Cycle_Count",
+	170: "Available_Reservd_Space",
+	171: "Program_Fail_Count",
+	172: "Erase_Fail_Count",
+	173: "Wear_Leveling_Count",
+	174: "Unexpect_Power_Loss_Ct",
+	177: "Wear_Leveling_Count",
+	179: "Used_Rsvd_Blk_Cnt_Tot",
+	181: "Program_Fail_Cnt_Total",
+	182: "Erase_Fail_Count_Total",
+	183: "Runtime_Bad_Block",
+	184: "End-to-End_Error",
+	187: "Reported_Uncorrect",
+	188: "Command_Timeout",
+	190: "Airflow_Temperature_Cel",
+	192: "Power-Off_Retract_Count",
+	193: "Load_Cycle_Count",
+	194: "Temperature_Celsius",
+	195: "Hardware_ECC_Recovered",
+	196: "Reallocated_Event_Count",
+	197: "Current_Pending_Sector",
+	198: "Offline_Uncorrectable",
+	199: "UDMA_CRC_Error_Count",
+	202: "Percent_Lifetime_Remain",
+	231: "SSD_Life_Left",
+	232: "Available_Reservd_Space",
+	233: "Media_Wearout_Indicator",
+	241: "Total_LBAs_Written",
+	242: "Total_LBAs_Read",
+}
+
+type smartAttribute struct {
+	ID        uint8  `json:"id"`
+	Name      string `json:"name"`
+	Value     uint8  `json:"value"`
+	Worst     uint8  `json:"worst"`
+	Threshold uint8  `json:"threshold"`
+	Raw       uint64 `json:"raw"`
+	Failing   bool   `json:"failing"`
+}
+
+// displayRaw : interpret the raw counter the way most vendors pack it
+func (a smartAttribute) displayRaw() uint64 {
+	switch a.ID {
+	case 190, 194:
+		// current temperature lives in the low byte, min/max in the bytes above
+		return a.Raw & 0xff
+	case 9:
+		// some vendors keep minutes/milliseconds in the upper bytes
+		return a.Raw & 0xffffffff
+	default:
+		return a.Raw
+	}
+}
+
+// ataReadPIO : issue a PIO data-in ATA command via ATA PASS-THROUGH(16), retrying with the 12 byte form
+func ataReadPIO(f *os.File, command uint8, feature uint8, lbaMid uint8, lbaHigh uint8, buf []byte) error {
+	sectors := uint8(len(buf) / ataSectorSize)
+	// byte 2: T_DIR=from device, BYT_BLOK=blocks, T_LENGTH=sector count field
+	cdb16 := []byte{0x85, ataProtoPioDataIn << 1, 0x0e, 0, feature, 0, sectors, 0, 0, 0, lbaMid, 0, lbaHigh, 0, command, 0}
+	err := sgioCommand(f, cdb16, buf, sgDxferFromDev)
+	if err == nil {
+		return nil
+	}
+	// some USB bridges and older SATLs only implement the 12 byte CDB
+	cdb12 := []byte{0xa1, ataProtoPioDataIn << 1, 0x0e, feature, sectors, 0, lbaMid, lbaHigh, 0, command, 0, 0}
+	return sgioCommand(f, cdb12, buf, sgDxferFromDev)
+}
+
+// decodeSmartAttributes : combine SMART READ DATA and READ THRESHOLDS sectors into an attribute table
+func decodeSmartAttributes(data []byte, thresholds []byte) []smartAttribute {
+	var attrs []smartAttribute
+
+	limits := make(map[uint8]uint8)
+	for i := 0; i < smartAttrCount; i++ {
+		entry := thresholds[2+i*smartAttrLen : 2+(i+1)*smartAttrLen]
+		if entry[0] != 0 {
+			limits[entry[0]] = entry[1]
+		}
+	}
+
+	for i := 0; i < smartAttrCount; i++ {
+		entry := data[2+i*smartAttrLen : 2+(i+1)*smartAttrLen]
+		if entry[0] == 0 {
+			continue
+		}
+		raw := make([]byte, 8)
+		copy(raw, entry[5:11])
+
+		attr := smartAttribute{
+			ID:        entry[0],
+			Name:      smartAttributeNames[entry[0]],
+			Value:     entry[3],
+			Worst:     entry[4],
+			Threshold: limits[entry[0]],
+			Raw:       binary.LittleEndian.Uint64(raw),
+		}
+		if attr.Name == "" {
+			attr.Name = "Unknown_Attribute"
+		}
+		attr.Failing = attr.Threshold != 0 && attr.Value <= attr.Threshold
+		attrs = append(attrs, attr)
+	}
+	return attrs
+}
+
+// ataSmartGet : read the SMART attribute table from an ATA disk
+func ataSmartGet(devPath string) ([]smartAttribute, error) {
+	f, err := openPassThrough(devPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, ataSectorSize)
+	if err = ataReadPIO(f, ataCmdSmart, ataSmartReadData, ataSmartLbaMid, ataSmartLbaHigh, data); err != nil {
+		return nil, err
+	}
+	thresholds := make([]byte, ataSectorSize)
+	if err = ataReadPIO(f, ataCmdSmart, ataSmartReadThreshold, ataSmartLbaMid, ataSmartLbaHigh, thresholds); err != nil {
+		return nil, err
+	}
+
+	attrs := decodeSmartAttributes(data, thresholds)
+	if len(attrs) == 0 {
+		return nil, errors.New("no SMART attributes reported by " + devPath)
+	}
+	return attrs, nil
+}
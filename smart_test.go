@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// smartSector : a SMART READ DATA or READ THRESHOLDS sector with the given 12 byte entries in its slots
+func smartSector(entries map[int][]byte) []byte {
+	sector := make([]byte, ataSectorSize)
+	sector[0] = 0x10 // revision
+	for slot, entry := range entries {
+		copy(sector[2+slot*smartAttrLen:], entry)
+	}
+	return sector
+}
+
+var smartAttributeTests = []struct {
+	name       string
+	data       []byte
+	thresholds []byte
+	want       []smartAttribute
+}{
+	{
+		name: "healthy disk",
+		// id, flags(2), value, worst, raw(6), reserved
+		data: smartSector(map[int][]byte{
+			0: {5, 0x33, 0x00, 100, 100, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0},
+			1: {9, 0x32, 0x00, 91, 91, 0x2c, 0x1f, 0x00, 0x00, 0x12, 0x00, 0},
+			2: {194, 0x22, 0x00, 64, 45, 0x24, 0x00, 0x12, 0x00, 0x37, 0x00, 0},
+			3: {250, 0x32, 0x00, 200, 200, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0},
+		}),
+		// id, threshold
+		thresholds: smartSector(map[int][]byte{
+			0: {5, 10},
+			1: {9, 0},
+			2: {194, 0},
+			3: {250, 0},
+		}),
+		want: []smartAttribute{
+			{ID: 5, Name: "Reallocated_Sector_Ct", Value: 100, Worst: 100, Threshold: 10, Raw: 2},
+			{ID: 9, Name: "Power_On_Hours", Value: 91, Worst: 91, Raw: 0x1200001f2c},
+			{ID: 194, Name: "Temperature_Celsius", Value: 64, Worst: 45, Raw: 0x3700120024},
+			{ID: 250, Name: "Unknown_Attribute", Value: 200, Worst: 200, Raw: 1},
+		},
+	},
+	{
+		name: "attribute at its threshold, empty slots skipped",
+		data: smartSector(map[int][]byte{
+			4:  {5, 0x33, 0x00, 36, 36, 0xd0, 0x07, 0x00, 0x00, 0x00, 0x00, 0},
+			29: {197, 0x12, 0x00, 100, 100, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0},
+		}),
+		thresholds: smartSector(map[int][]byte{
+			0: {197, 0},
+			7: {5, 36},
+		}),
+		want: []smartAttribute{
+			{ID: 5, Name: "Reallocated_Sector_Ct", Value: 36, Worst: 36, Threshold: 36, Raw: 2000, Failing: true},
+			{ID: 197, Name: "Current_Pending_Sector", Value: 100, Worst: 100, Raw: 8},
+		},
+	},
+}
+
+func TestDecodeSmartAttributes(t *testing.T) {
+	for _, test := range smartAttributeTests {
+		got := decodeSmartAttributes(test.data, test.thresholds)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: decodeSmartAttributes =\n%+v\nwant\n%+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSmartDisplayRaw(t *testing.T) {
+	tests := []struct {
+		attr smartAttribute
+		want uint64
+	}{
+		{smartAttribute{ID: 194, Raw: 0x3700120024}, 0x24},
+		{smartAttribute{ID: 190, Raw: 0x2a0019001f}, 0x1f},
+		{smartAttribute{ID: 9, Raw: 0x1200001f2c}, 0x1f2c},
+		{smartAttribute{ID: 5, Raw: 0x1200001f2c}, 0x1200001f2c},
+	}
+	for _, test := range tests {
+		if got := test.attr.displayRaw(); got != test.want {
+			t.Errorf("displayRaw(id %d, raw %#x) = %#x, want %#x", test.attr.ID, test.attr.Raw, got, test.want)
+		}
+	}
+}
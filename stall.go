@@ -0,0 +1,208 @@
+package main
+
+// Detection of disks that stop completing I/O without failing a health
+// check. A device is stalled when it has requests in flight at every sample
+// and its completion counters do not move for the whole detection period.
+// SCSI device state transitions (running -> offline/blocked) are reported too.
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const stallExitCode = 2
+
+var badScsiStates = map[string]bool{
+	"offline":           true,
+	"transport-offline": true,
+	"blocked":           true,
+	"created-blocked":   true,
+	"cancel":            true,
+	"deleted":           true,
+}
+
+type stallReport struct {
+	Device       string   `json:"device"`
+	ScsiState    string   `json:"scsi_state,omitempty"`
+	StateChanges []string `json:"state_changes,omitempty"`
+	InFlight     uint64   `json:"in_flight"`
+	NoProgressS  float64  `json:"no_progress_seconds"`
+	Stalled      bool     `json:"stalled"`
+	Problem      bool     `json:"problem"`
+	Reason       string   `json:"reason,omitempty"`
+
+	lastCompleted uint64
+	lastProgress  time.Time
+}
+
+// readInFlight : read the outstanding read and write request counts of a block device
+func readInFlight(devName string) (uint64, error) {
+	content, err := readFile("/sys/block/" + devName + "/inflight")
+	if err != nil {
+		return 0, err
+	}
+	var total uint64
+	for _, field := range strings.Fields(content) {
+		n, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// completedIOs : total requests completed according to the stat counters
+func completedIOs(stat blockStat) uint64 {
+	return stat.fields[statReadIOs] + stat.fields[statWriteIOs] + stat.fields[statDiscardIOs] + stat.fields[statFlushIOs]
+}
+
+// sample : record one observation of a device's progress and SCSI state
+func (r *stallReport) sample(now time.Time) {
+	inFlight, errInFlight := readInFlight(r.Device)
+	stat, errStat := readBlockStat(r.Device)
+	if errInFlight == nil && errStat == nil {
+		completed := completedIOs(stat)
+		r.InFlight = inFlight
+		if inFlight == 0 || completed != r.lastCompleted || r.lastProgress.IsZero() {
+			r.lastProgress = now
+		}
+		r.lastCompleted = completed
+		r.NoProgressS = now.Sub(r.lastProgress).Seconds()
+	}
+
+	// only SCSI devices have a state attribute
+	if state, err := getDeviceAttr("/dev/"+r.Device, "state"); err == nil {
+		if r.ScsiState != "" && state != r.ScsiState {
+			r.StateChanges = append(r.StateChanges, r.ScsiState+"->"+state)
+		}
+		r.ScsiState = state
+	}
+}
+
+// detectStalls : sample the given devices for period and report which ones stopped making progress
+func detectStalls(devNames []string, period time.Duration, interval time.Duration) map[string]*stallReport {
+	reports := make(map[string]*stallReport)
+
+	start := time.Now()
+	last := start
+	for _, devName := range devNames {
+		reports[devName] = &stallReport{Device: devName}
+		reports[devName].sample(start)
+	}
+	for time.Since(start) < period {
+		time.Sleep(interval)
+		last = time.Now()
+		for _, r := range reports {
+			r.sample(last)
+		}
+	}
+
+	// the last sample can land just short of start+period, so compare against the window actually sampled
+	window := last.Sub(start).Seconds()
+	for _, r := range reports {
+		var reasons []string
+		r.Stalled = r.InFlight > 0 && window > 0 && r.NoProgressS >= window
+		if r.Stalled {
+			reasons = append(reasons, fmt.Sprintf("%d requests in flight with no completions for %.0fs", r.InFlight, r.NoProgressS))
+		}
+		if badScsiStates[r.ScsiState] {
+			reasons = append(reasons, "scsi device state is "+r.ScsiState)
+		}
+		if len(r.StateChanges) > 0 {
+			reasons = append(reasons, "scsi device state changed "+strings.Join(r.StateChanges, ", "))
+		}
+		r.Problem = r.Stalled || badScsiStates[r.ScsiState]
+		r.Reason = strings.Join(reasons, "; ")
+	}
+	return reports
+}
+
+// stallCommand : run the stalled I/O detector across all local disks
+func stallCommand(args []string) int {
+	fs := flag.NewFlagSet("stall", flag.ExitOnError)
+	period := fs.Duration("period", 30*time.Second, "report devices with outstanding I/O and no completions for this long")
+	interval := fs.Duration("interval", time.Second, "sampling interval")
+	fs.StringVar(&outputFormat, "output", "text", "output format (text or json)")
+	addBackendFlag(fs)
+	addRootFlags(fs)
+	fs.Parse(args)
+
+	if outputFormat != "text" && outputFormat != "json" {
+		fmt.Println("Unsupported output format " + outputFormat)
+		return 1
+	}
+	if *period <= 0 || *interval <= 0 {
+		fmt.Println("The period and interval must be positive")
+		return 1
+	}
+
+	if err := applyRootFlags(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := initBackend(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	disks, err := backend.list()
+	if err != nil || len(disks) == 0 {
+		fmt.Println("No local disks found")
+		return 1
+	}
+	var devNames []string
+	for _, devPath := range disks {
+		devName, _ := extractDev(devPath)
+		devNames = append(devNames, devName)
+	}
+
+	reports := detectStalls(devNames, *period, *interval)
+
+	rc := 0
+	var ordered []*stallReport
+	for _, devName := range devNames {
+		ordered = append(ordered, reports[devName])
+		if reports[devName].Problem {
+			rc = stallExitCode
+		}
+	}
+
+	if outputFormat == "json" {
+		printJSON(ordered)
+		return rc
+	}
+	fmt.Printf("%-12s %-18s %9s %12s %-8s %s\n", "Device", "SCSI State", "In Flight", "No Progress", "Status", "Reason")
+	for _, r := range ordered {
+		status := "OK"
+		if r.Problem {
+			status = "PROBLEM"
+		}
+		state := r.ScsiState
+		if state == "" {
+			state = "-"
+		}
+		fmt.Printf("%-12s %-18s %9d %11.0fs %-8s %s\n", r.Device, state, r.InFlight, r.NoProgressS, status, r.Reason)
+	}
+	return rc
+}
+
+// applyStallReports : feed stall detector results into the health of listed disks
+func applyStallReports(disks []disk, reports map[string]*stallReport) int {
+	rc := 0
+	for i := range disks {
+		devName, _ := extractDev(disks[i].devPath)
+		r, ok := reports[devName]
+		if !ok {
+			continue
+		}
+		disks[i].stall = r
+		if r.Problem {
+			disks[i].health = "Fail"
+			rc = stallExitCode
+		}
+	}
+	return rc
+}
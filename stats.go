@@ -0,0 +1,241 @@
+package main
+
+// iostat style sampling of /sys/block/<dev>/stat. The field layout is
+// described in Documentation/admin-guide/iostats.rst; discard counters were
+// added in 4.18 and flush counters in 5.5, so they are optional.
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	statReadIOs = iota
+	statReadMerges
+	statReadSectors
+	statReadTicks
+	statWriteIOs
+	statWriteMerges
+	statWriteSectors
+	statWriteTicks
+	statInFlight
+	statIoTicks
+	statTimeInQueue
+	statDiscardIOs
+	statDiscardMerges
+	statDiscardSectors
+	statDiscardTicks
+	statFlushIOs
+	statFlushTicks
+	statFields
+)
+
+const statSectorBytes = 512
+
+type blockStat struct {
+	fields [statFields]uint64
+	count  int
+}
+
+type diskIOStats struct {
+	Device           string   `json:"device"`
+	ReadIOPS         float64  `json:"read_iops"`
+	WriteIOPS        float64  `json:"write_iops"`
+	ReadBytesPerSec  float64  `json:"read_bytes_per_sec"`
+	WriteBytesPerSec float64  `json:"write_bytes_per_sec"`
+	AvgRequestBytes  float64  `json:"avg_request_bytes"`
+	ReadAwaitMs      float64  `json:"read_await_ms"`
+	WriteAwaitMs     float64  `json:"write_await_ms"`
+	AwaitMs          float64  `json:"await_ms"`
+	QueueDepth       float64  `json:"queue_depth"`
+	InFlight         uint64   `json:"in_flight"`
+	Utilization      float64  `json:"utilization_pct"`
+	DiscardIOPS      *float64 `json:"discard_iops,omitempty"`
+	DiscardBytesSec  *float64 `json:"discard_bytes_per_sec,omitempty"`
+	FlushIOPS        *float64 `json:"flush_iops,omitempty"`
+}
+
+type statsSample struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Interval  float64       `json:"interval_sec"`
+	Disks     []diskIOStats `json:"disks"`
+}
+
+// readBlockStat : read the stat counters of a block device
+func readBlockStat(devName string) (blockStat, error) {
+	var stat blockStat
+
+	content, err := readFile("/sys/block/" + devName + "/stat")
+	if err != nil {
+		return stat, err
+	}
+	for i, field := range strings.Fields(content) {
+		if i >= statFields {
+			break
+		}
+		stat.fields[i], err = strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return stat, errors.New("malformed stat counters for " + devName)
+		}
+		stat.count = i + 1
+	}
+	if stat.count < statDiscardIOs {
+		return stat, errors.New("short stat counters for " + devName)
+	}
+	return stat, nil
+}
+
+// perOp : average a tick delta over an I/O count delta
+func perOp(ticks uint64, ios uint64) float64 {
+	if ios == 0 {
+		return 0
+	}
+	return float64(ticks) / float64(ios)
+}
+
+// diffBlockStat : derive rates from two samples taken secs apart
+func diffBlockStat(devName string, prev blockStat, cur blockStat, secs float64) diskIOStats {
+	delta := func(field int) uint64 {
+		// counters can wrap on 32 bit kernels; treat a decrease as no activity
+		if cur.fields[field] < prev.fields[field] {
+			return 0
+		}
+		return cur.fields[field] - prev.fields[field]
+	}
+
+	reads, writes := delta(statReadIOs), delta(statWriteIOs)
+	readSectors, writeSectors := delta(statReadSectors), delta(statWriteSectors)
+	intervalMs := secs * 1000
+
+	stats := diskIOStats{
+		Device:           devName,
+		ReadIOPS:         float64(reads) / secs,
+		WriteIOPS:        float64(writes) / secs,
+		ReadBytesPerSec:  float64(readSectors*statSectorBytes) / secs,
+		WriteBytesPerSec: float64(writeSectors*statSectorBytes) / secs,
+		AvgRequestBytes:  perOp((readSectors+writeSectors)*statSectorBytes, reads+writes),
+		ReadAwaitMs:      perOp(delta(statReadTicks), reads),
+		WriteAwaitMs:     perOp(delta(statWriteTicks), writes),
+		AwaitMs:          perOp(delta(statReadTicks)+delta(statWriteTicks), reads+writes),
+		QueueDepth:       float64(delta(statTimeInQueue)) / intervalMs,
+		InFlight:         cur.fields[statInFlight],
+		Utilization:      100 * float64(delta(statIoTicks)) / intervalMs,
+	}
+	if stats.Utilization > 100 {
+		stats.Utilization = 100
+	}
+	if cur.count > statDiscardTicks && prev.count > statDiscardTicks {
+		iops := float64(delta(statDiscardIOs)) / secs
+		bytes := float64(delta(statDiscardSectors)*statSectorBytes) / secs
+		stats.DiscardIOPS, stats.DiscardBytesSec = &iops, &bytes
+	}
+	if cur.count > statFlushTicks && prev.count > statFlushTicks {
+		iops := float64(delta(statFlushIOs)) / secs
+		stats.FlushIOPS = &iops
+	}
+	return stats
+}
+
+// optionalRate : format an optional counter, "-" when the kernel doesn't provide it
+func optionalRate(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", *v)
+}
+
+// printStatsSample : print one interval in the selected output format
+func printStatsSample(sample statsSample) {
+	if outputFormat == "json" {
+		// one object per line so the stream can be consumed incrementally
+		out, _ := json.Marshal(sample)
+		fmt.Println(string(out))
+		return
+	}
+
+	fmt.Println(sample.Timestamp.Format(time.RFC3339))
+	fmt.Printf("%-12s %8s %8s %10s %10s %9s %8s %8s %7s %6s %8s %10s %8s\n",
+		"Device", "r/s", "w/s", "rMB/s", "wMB/s", "avgrq-kB", "r_await", "w_await", "aqu-sz", "%util",
+		"d/s", "dMB/s", "f/s")
+	for _, s := range sample.Disks {
+		dMBs := "-"
+		if s.DiscardBytesSec != nil {
+			dMBs = fmt.Sprintf("%.2f", *s.DiscardBytesSec/1048576)
+		}
+		fmt.Printf("%-12s %8.1f %8.1f %10.2f %10.2f %9.1f %8.2f %8.2f %7.2f %6.1f %8s %10s %8s\n",
+			s.Device, s.ReadIOPS, s.WriteIOPS, s.ReadBytesPerSec/1048576, s.WriteBytesPerSec/1048576,
+			s.AvgRequestBytes/1024, s.ReadAwaitMs, s.WriteAwaitMs, s.QueueDepth, s.Utilization,
+			optionalRate(s.DiscardIOPS), dMBs, optionalRate(s.FlushIOPS))
+	}
+	fmt.Println()
+}
+
+// statsCommand : sample I/O statistics for every local disk at a fixed interval
+func statsCommand(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	interval := fs.Duration("interval", time.Second, "sampling interval")
+	count := fs.Int("count", 0, "number of intervals to report (0 runs until interrupted)")
+	fs.StringVar(&outputFormat, "output", "text", "output format (text or json)")
+	addBackendFlag(fs)
+	addRootFlags(fs)
+	fs.Parse(args)
+
+	if outputFormat != "text" && outputFormat != "json" {
+		fmt.Println("Unsupported output format " + outputFormat)
+		return 1
+	}
+	if *interval <= 0 {
+		fmt.Println("The interval must be positive")
+		return 1
+	}
+
+	if err := applyRootFlags(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := initBackend(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	disks, err := backend.list()
+	if err != nil || len(disks) == 0 {
+		fmt.Println("No local disks found")
+		return 1
+	}
+
+	var devNames []string
+	prev := make(map[string]blockStat)
+	for _, devPath := range disks {
+		devName, _ := extractDev(devPath)
+		stat, err := readBlockStat(devName)
+		if err != nil {
+			continue
+		}
+		devNames = append(devNames, devName)
+		prev[devName] = stat
+	}
+	last := time.Now()
+
+	for n := 0; *count == 0 || n < *count; n++ {
+		time.Sleep(*interval)
+		now := time.Now()
+		sample := statsSample{Timestamp: now, Interval: now.Sub(last).Seconds(), Disks: []diskIOStats{}}
+		for _, devName := range devNames {
+			stat, err := readBlockStat(devName)
+			if err != nil {
+				// the disk went away mid-run; keep reporting the others
+				continue
+			}
+			sample.Disks = append(sample.Disks, diffBlockStat(devName, prev[devName], stat, sample.Interval))
+			prev[devName] = stat
+		}
+		last = now
+		printStatsSample(sample)
+	}
+	return 0
+}
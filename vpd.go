@@ -0,0 +1,311 @@
+package main
+
+// SCSI INQUIRY vital product data pages. Each page is a 4 byte header (page
+// code at byte 1, length at bytes 2-3) followed by page specific data.
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	scsiOpInquiry          = 0x12
+	vpdPageSupported       = 0x00
+	vpdPageUnitSerial      = 0x80
+	vpdPageDeviceID        = 0x83
+	vpdPageBlockLimits     = 0xb0
+	vpdPageBlockDevChars   = 0xb1
+	vpdPageProvisioning    = 0xb2
+	vpdInitialAllocLength  = 252
+	rotationNonRotating    = 1
+	designatorCodeSetBin   = 1
+	designatorTypeVendor   = 0
+	designatorTypeT10      = 1
+	designatorTypeEUI64    = 2
+	designatorTypeNAA      = 3
+	designatorTypeRelPort  = 4
+	designatorTypePortGrp  = 5
+	designatorTypeLUGroup  = 6
+	designatorTypeMD5      = 7
+	designatorTypeName     = 8
+	designatorTypeProtPort = 9
+	designatorTypeUUID     = 0xa
+)
+
+var decodedVPDPages = map[uint8]bool{
+	vpdPageUnitSerial:    true,
+	vpdPageDeviceID:      true,
+	vpdPageBlockLimits:   true,
+	vpdPageBlockDevChars: true,
+	vpdPageProvisioning:  true,
+}
+
+var designatorTypeText = map[uint8]string{
+	designatorTypeVendor:   "vendor specific",
+	designatorTypeT10:      "t10 vendor id",
+	designatorTypeEUI64:    "eui-64",
+	designatorTypeNAA:      "naa",
+	designatorTypeRelPort:  "relative target port",
+	designatorTypePortGrp:  "target port group",
+	designatorTypeLUGroup:  "logical unit group",
+	designatorTypeMD5:      "md5 logical unit",
+	designatorTypeName:     "scsi name string",
+	designatorTypeProtPort: "protocol specific port",
+	designatorTypeUUID:     "uuid",
+}
+
+var associationText = []string{"logical unit", "target port", "target device", "reserved"}
+
+var formFactorText = map[uint8]string{
+	0: "Not reported",
+	1: "5.25 inch",
+	2: "3.5 inch",
+	3: "2.5 inch",
+	4: "1.8 inch",
+	5: "Less than 1.8 inch",
+}
+
+var zonedText = map[uint8]string{
+	0: "Not reported",
+	1: "Host aware",
+	2: "Device managed",
+}
+
+var provisioningTypeText = map[uint8]string{
+	0: "Fully provisioned",
+	1: "Resource provisioned",
+	2: "Thin provisioned",
+}
+
+type vpdDesignator struct {
+	Association string `json:"association"`
+	Type        string `json:"type"`
+	Value       string `json:"value"`
+}
+
+type vpdBlockLimits struct {
+	MaxCompareWriteLength      uint8  `json:"max_compare_write_length"`
+	OptimalTransferGranularity uint16 `json:"optimal_transfer_length_granularity"`
+	MaxTransferLength          uint32 `json:"max_transfer_length"`
+	OptimalTransferLength      uint32 `json:"optimal_transfer_length"`
+	MaxPrefetchLength          uint32 `json:"max_prefetch_length"`
+	MaxUnmapLBACount           uint32 `json:"max_unmap_lba_count"`
+	MaxUnmapDescriptorCount    uint32 `json:"max_unmap_block_descriptor_count"`
+	OptimalUnmapGranularity    uint32 `json:"optimal_unmap_granularity"`
+	UnmapGranularityAlignment  uint32 `json:"unmap_granularity_alignment"`
+	UnmapAlignmentValid        bool   `json:"unmap_granularity_alignment_valid"`
+	MaxWriteSameLength         uint64 `json:"max_write_same_length"`
+}
+
+type vpdBlockCharacteristics struct {
+	RotationRate uint16 `json:"rotation_rate"`
+	Rotation     string `json:"rotation"`
+	FormFactor   string `json:"form_factor"`
+	Zoned        string `json:"zoned"`
+}
+
+type vpdProvisioning struct {
+	Unmap             bool   `json:"unmap"`
+	WriteSame16Unmap  bool   `json:"write_same_16_unmap"`
+	WriteSame10Unmap  bool   `json:"write_same_10_unmap"`
+	ReadZeroes        uint8  `json:"lbprz"`
+	AnchorSupported   bool   `json:"anchor_supported"`
+	DescriptorPresent bool   `json:"descriptor_present"`
+	ThresholdExponent uint8  `json:"threshold_exponent"`
+	ProvisioningType  string `json:"provisioning_type"`
+}
+
+type vpdPages struct {
+	Supported            []string                 `json:"supported_pages"`
+	UnitSerial           string                   `json:"unit_serial,omitempty"`
+	Designators          []vpdDesignator          `json:"designators,omitempty"`
+	BlockLimits          *vpdBlockLimits          `json:"block_limits,omitempty"`
+	BlockCharacteristics *vpdBlockCharacteristics `json:"block_characteristics,omitempty"`
+	Provisioning         *vpdProvisioning         `json:"provisioning,omitempty"`
+}
+
+// inquiryVPD : read a VPD page, retrying with the full length when the first read is too short
+func inquiryVPD(f *os.File, page uint8) ([]byte, error) {
+	alloc := vpdInitialAllocLength
+	for {
+		buf := make([]byte, alloc)
+		cdb := []byte{scsiOpInquiry, 0x01, page, byte(alloc >> 8), byte(alloc), 0}
+		if err := sgioCommand(f, cdb, buf, sgDxferFromDev); err != nil {
+			return nil, err
+		}
+		if buf[1] != page {
+			return nil, fmt.Errorf("VPD page 0x%02x: device returned page 0x%02x", page, buf[1])
+		}
+		length := 4 + int(binary.BigEndian.Uint16(buf[2:4]))
+		if length <= alloc {
+			return buf[:length], nil
+		}
+		alloc = length
+	}
+}
+
+// vpdString : trim the padding from an ascii VPD field
+func vpdString(b []byte) string {
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
+
+// decodeDesignator : format a single identification descriptor value
+func decodeDesignator(codeSet uint8, dtype uint8, value []byte) string {
+	switch dtype {
+	case designatorTypeNAA:
+		return "naa." + hex.EncodeToString(value)
+	case designatorTypeEUI64:
+		return "eui." + hex.EncodeToString(value)
+	case designatorTypeRelPort, designatorTypePortGrp, designatorTypeLUGroup:
+		if len(value) >= 4 {
+			return fmt.Sprintf("%d", binary.BigEndian.Uint16(value[2:4]))
+		}
+	case designatorTypeUUID:
+		if len(value) >= 18 {
+			u := hex.EncodeToString(value[2:18])
+			return u[0:8] + "-" + u[8:12] + "-" + u[12:16] + "-" + u[16:20] + "-" + u[20:32]
+		}
+	}
+	if codeSet == designatorCodeSetBin {
+		return hex.EncodeToString(value)
+	}
+	return vpdString(value)
+}
+
+// decodeDeviceID : decode every designation descriptor of the device identification page
+func decodeDeviceID(page []byte) []vpdDesignator {
+	var designators []vpdDesignator
+
+	for off := 4; off+4 <= len(page); {
+		length := int(page[off+3])
+		if off+4+length > len(page) {
+			break
+		}
+		codeSet := page[off] & 0x0f
+		assoc := (page[off+1] >> 4) & 0x03
+		dtype := page[off+1] & 0x0f
+
+		typeText, ok := designatorTypeText[dtype]
+		if !ok {
+			typeText = fmt.Sprintf("reserved (0x%x)", dtype)
+		}
+		designators = append(designators, vpdDesignator{
+			Association: associationText[assoc],
+			Type:        typeText,
+			Value:       decodeDesignator(codeSet, dtype, page[off+4:off+4+length]),
+		})
+		off += 4 + length
+	}
+	return designators
+}
+
+// decodeBlockLimits : decode the block limits page
+func decodeBlockLimits(page []byte) *vpdBlockLimits {
+	if len(page) < 44 {
+		return nil
+	}
+	return &vpdBlockLimits{
+		MaxCompareWriteLength:      page[5],
+		OptimalTransferGranularity: binary.BigEndian.Uint16(page[6:8]),
+		MaxTransferLength:          binary.BigEndian.Uint32(page[8:12]),
+		OptimalTransferLength:      binary.BigEndian.Uint32(page[12:16]),
+		MaxPrefetchLength:          binary.BigEndian.Uint32(page[16:20]),
+		MaxUnmapLBACount:           binary.BigEndian.Uint32(page[20:24]),
+		MaxUnmapDescriptorCount:    binary.BigEndian.Uint32(page[24:28]),
+		OptimalUnmapGranularity:    binary.BigEndian.Uint32(page[28:32]),
+		UnmapGranularityAlignment:  binary.BigEndian.Uint32(page[32:36]) & 0x7fffffff,
+		UnmapAlignmentValid:        page[32]&0x80 != 0,
+		MaxWriteSameLength:         binary.BigEndian.Uint64(page[36:44]),
+	}
+}
+
+// decodeBlockCharacteristics : decode the block device characteristics page
+func decodeBlockCharacteristics(page []byte) *vpdBlockCharacteristics {
+	if len(page) < 9 {
+		return nil
+	}
+	chars := &vpdBlockCharacteristics{
+		RotationRate: binary.BigEndian.Uint16(page[4:6]),
+		FormFactor:   formFactorText[page[7]&0x0f],
+		Zoned:        zonedText[(page[8]>>4)&0x03],
+	}
+	switch chars.RotationRate {
+	case 0:
+		chars.Rotation = "Not reported"
+	case rotationNonRotating:
+		chars.Rotation = "Non-rotating medium"
+	default:
+		chars.Rotation = fmt.Sprintf("%d rpm", chars.RotationRate)
+	}
+	if chars.FormFactor == "" {
+		chars.FormFactor = "Reserved"
+	}
+	if chars.Zoned == "" {
+		chars.Zoned = "Reserved"
+	}
+	return chars
+}
+
+// decodeProvisioning : decode the logical block provisioning page
+func decodeProvisioning(page []byte) *vpdProvisioning {
+	if len(page) < 8 {
+		return nil
+	}
+	prov := &vpdProvisioning{
+		ThresholdExponent: page[4],
+		Unmap:             page[5]&0x80 != 0,
+		WriteSame16Unmap:  page[5]&0x40 != 0,
+		WriteSame10Unmap:  page[5]&0x20 != 0,
+		ReadZeroes:        (page[5] >> 2) & 0x07,
+		AnchorSupported:   page[5]&0x02 != 0,
+		DescriptorPresent: page[5]&0x01 != 0,
+		ProvisioningType:  provisioningTypeText[page[6]&0x07],
+	}
+	if prov.ProvisioningType == "" {
+		prov.ProvisioningType = "Reserved"
+	}
+	return prov
+}
+
+// vpdPagesGet : read and decode the VPD pages a SCSI (or SAT) disk supports
+func vpdPagesGet(devPath string) (*vpdPages, error) {
+	f, err := openPassThrough(devPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	supported, err := inquiryVPD(f, vpdPageSupported)
+	if err != nil {
+		return nil, err
+	}
+
+	vpd := &vpdPages{}
+	for _, code := range supported[4:] {
+		vpd.Supported = append(vpd.Supported, fmt.Sprintf("0x%02x", code))
+
+		if !decodedVPDPages[code] {
+			continue
+		}
+		page, err := inquiryVPD(f, code)
+		if err != nil {
+			continue
+		}
+		switch code {
+		case vpdPageUnitSerial:
+			vpd.UnitSerial = vpdString(page[4:])
+		case vpdPageDeviceID:
+			vpd.Designators = decodeDeviceID(page)
+		case vpdPageBlockLimits:
+			vpd.BlockLimits = decodeBlockLimits(page)
+		case vpdPageBlockDevChars:
+			vpd.BlockCharacteristics = decodeBlockCharacteristics(page)
+		case vpdPageProvisioning:
+			vpd.Provisioning = decodeProvisioning(page)
+		}
+	}
+	return vpd, nil
+}
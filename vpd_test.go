@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// vpdPage : a VPD page with the given data after its header
+func vpdPage(code uint8, data []byte) []byte {
+	page := []byte{0, code, 0, 0}
+	binary.BigEndian.PutUint16(page[2:4], uint16(len(data)))
+	return append(page, data...)
+}
+
+// designator : a designation descriptor of the device identification page
+func designator(codeSet uint8, assoc uint8, dtype uint8, value []byte) []byte {
+	return append([]byte{codeSet, assoc<<4 | dtype, 0, byte(len(value))}, value...)
+}
+
+// joinBytes : the descriptors of a page, one after the other
+func joinBytes(parts ...[]byte) []byte {
+	var b []byte
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+func TestDecodeDeviceID(t *testing.T) {
+	uuid := []byte{0x10, 0, 0x60, 0x0a, 0x09, 0x80, 0x38, 0x30, 0x33, 0x56, 0x3f, 0x5d, 0x4a, 0x36, 0x46, 0x43, 0x4c, 0x39}
+	tests := []struct {
+		name string
+		page []byte
+		want []vpdDesignator
+	}{
+		{
+			name: "sas disk",
+			page: vpdPage(vpdPageDeviceID, joinBytes(
+				designator(1, 0, designatorTypeNAA, []byte{0x50, 0x00, 0xc5, 0x00, 0xa1, 0xb2, 0xc3, 0xd4}),
+				designator(1, 1, designatorTypeNAA, []byte{0x50, 0x00, 0xc5, 0x00, 0xa1, 0xb2, 0xc3, 0xd5}),
+				designator(1, 1, designatorTypeRelPort, []byte{0, 0, 0, 1}),
+				designator(2, 0, designatorTypeT10, []byte("ATA     ST4000NM0035  ZC1234AB\x00\x00")),
+			)),
+			want: []vpdDesignator{
+				{Association: "logical unit", Type: "naa", Value: "naa.5000c500a1b2c3d4"},
+				{Association: "target port", Type: "naa", Value: "naa.5000c500a1b2c3d5"},
+				{Association: "target port", Type: "relative target port", Value: "1"},
+				{Association: "logical unit", Type: "t10 vendor id", Value: "ATA     ST4000NM0035  ZC1234AB"},
+			},
+		},
+		{
+			name: "eui-64, uuid and reserved types",
+			page: vpdPage(vpdPageDeviceID, joinBytes(
+				designator(1, 0, designatorTypeEUI64, []byte{0x00, 0x25, 0x38, 0x5a, 0x91, 0xb0, 0x1c, 0x2d}),
+				designator(1, 0, designatorTypeUUID, uuid),
+				designator(1, 2, 0xc, []byte{0xde, 0xad}),
+			)),
+			want: []vpdDesignator{
+				{Association: "logical unit", Type: "eui-64", Value: "eui.0025385a91b01c2d"},
+				{Association: "logical unit", Type: "uuid", Value: "600a0980-3830-3356-3f5d-4a3646434c39"},
+				{Association: "target device", Type: "reserved (0xc)", Value: "dead"},
+			},
+		},
+		{
+			name: "descriptor running past the page",
+			page: vpdPage(vpdPageDeviceID, joinBytes(
+				designator(1, 0, designatorTypeNAA, []byte{0x50, 0x00, 0xc5, 0x00, 0xa1, 0xb2, 0xc3, 0xd4}),
+				[]byte{1, designatorTypeNAA, 0, 16, 0x60, 0x00},
+			)),
+			want: []vpdDesignator{
+				{Association: "logical unit", Type: "naa", Value: "naa.5000c500a1b2c3d4"},
+			},
+		},
+	}
+	for _, test := range tests {
+		if got := decodeDeviceID(test.page); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: decodeDeviceID =\n%+v\nwant\n%+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDecodeBlockLimits(t *testing.T) {
+	data := make([]byte, 0x3c)
+	data[1] = 1
+	binary.BigEndian.PutUint16(data[2:4], 8)
+	binary.BigEndian.PutUint32(data[4:8], 0xffff)
+	binary.BigEndian.PutUint32(data[8:12], 0x200)
+	binary.BigEndian.PutUint32(data[16:20], 0x400000)
+	binary.BigEndian.PutUint32(data[20:24], 1)
+	binary.BigEndian.PutUint32(data[24:28], 8)
+	binary.BigEndian.PutUint32(data[28:32], 0x80000000)
+	binary.BigEndian.PutUint64(data[32:40], 0x3fffff)
+
+	want := vpdBlockLimits{MaxCompareWriteLength: 1, OptimalTransferGranularity: 8, MaxTransferLength: 0xffff,
+		OptimalTransferLength: 0x200, MaxUnmapLBACount: 0x400000, MaxUnmapDescriptorCount: 1,
+		OptimalUnmapGranularity: 8, UnmapAlignmentValid: true, MaxWriteSameLength: 0x3fffff}
+	if got := decodeBlockLimits(vpdPage(vpdPageBlockLimits, data)); got == nil || *got != want {
+		t.Errorf("decodeBlockLimits = %+v, want %+v", got, want)
+	}
+	if got := decodeBlockLimits(vpdPage(vpdPageBlockLimits, data[:0x10])); got != nil {
+		t.Errorf("decodeBlockLimits of a short page = %+v, want nil", got)
+	}
+}
+
+func TestDecodeBlockCharacteristics(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want *vpdBlockCharacteristics
+	}{
+		{[]byte{0x1c, 0x20, 0, 0x02, 0x00}, &vpdBlockCharacteristics{RotationRate: 7200, Rotation: "7200 rpm", FormFactor: "3.5 inch", Zoned: "Not reported"}},
+		{[]byte{0x00, 0x01, 0, 0x03, 0x10}, &vpdBlockCharacteristics{RotationRate: 1, Rotation: "Non-rotating medium", FormFactor: "2.5 inch", Zoned: "Host aware"}},
+		{[]byte{0x00, 0x00, 0, 0x07, 0x30}, &vpdBlockCharacteristics{Rotation: "Not reported", FormFactor: "Reserved", Zoned: "Reserved"}},
+		{[]byte{0x1c, 0x20, 0, 0x02}, nil},
+	}
+	for _, test := range tests {
+		if got := decodeBlockCharacteristics(vpdPage(vpdPageBlockDevChars, test.data)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("decodeBlockCharacteristics(% x) = %+v, want %+v", test.data, got, test.want)
+		}
+	}
+}
+
+func TestDecodeProvisioning(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want *vpdProvisioning
+	}{
+		{[]byte{0, 0xe4, 0x02, 0}, &vpdProvisioning{Unmap: true, WriteSame16Unmap: true, WriteSame10Unmap: true, ReadZeroes: 1, ProvisioningType: "Thin provisioned"}},
+		{[]byte{0x0c, 0x03, 0x01, 0}, &vpdProvisioning{ThresholdExponent: 12, AnchorSupported: true, DescriptorPresent: true, ProvisioningType: "Resource provisioned"}},
+		{[]byte{0, 0, 0x05, 0}, &vpdProvisioning{ProvisioningType: "Reserved"}},
+		{[]byte{0, 0xe4, 0x02}, nil},
+	}
+	for _, test := range tests {
+		if got := decodeProvisioning(vpdPage(vpdPageProvisioning, test.data)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("decodeProvisioning(% x) = %+v, want %+v", test.data, got, test.want)
+		}
+	}
+}